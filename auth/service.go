@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"tbp.com/user/hello/repository"
+	"time"
+)
+
+const (
+	Reader = "reader"
+	Editor = "editor"
+	Admin  = "admin"
+)
+
+// MaxClockSkew is how far the timestamp of a signed request may be off, to limit replaying it.
+const MaxClockSkew = 5 * time.Minute
+
+var roleLevels = map[string]int{Reader: 1, Editor: 2, Admin: 3}
+
+var (
+	errUnauthenticated = errors.New("missing or invalid credentials")
+	errUnauthorized    = errors.New("not allowed")
+)
+
+type Client struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	Role   string `json:"role"`
+}
+
+type Credentials struct {
+	Clients []Client `json:"clients"`
+}
+
+// Clients authenticate either with their secret as API key, in "Authorization: Bearer <secret>",
+// or by signing the request with it, in "Authorization: HMAC <id>:<signature>" together with "X-Timestamp: <unix seconds>".
+// The signature is the hex encoded HMAC-SHA256 of the method, request URI, timestamp and hex encoded SHA256 of the body, joined by newlines.
+type Service struct {
+	clients []Client
+}
+
+func Setup(repository repository.Repository) (*Service, error) {
+	var credentials Credentials
+	err := repository.ReadAll(&credentials)
+	if err != nil {
+		return nil, err
+	}
+	return SetupWith(credentials.Clients...)
+}
+
+func SetupWith(clients ...Client) (*Service, error) {
+	ids := make(map[string]bool)
+	for _, client := range clients {
+		if roleLevels[client.Role] == 0 {
+			return nil, fmt.Errorf("client %q has unknown role %q, must be one of %q, %q or %q", client.ID, client.Role, Reader, Editor, Admin)
+		}
+		if client.ID == "" || client.Secret == "" {
+			return nil, fmt.Errorf("every client must have an id and a secret, found %q", client.ID)
+		}
+		if ids[client.ID] {
+			return nil, fmt.Errorf("client ids must be unique, found %q multiple times", client.ID)
+		}
+		ids[client.ID] = true
+	}
+	return &Service{clients: clients}, nil
+}
+
+// Require only lets requests through from clients with at least the given role.
+func (s *Service) Require(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := s.authenticate(r)
+		if err == nil && roleLevels[client.Role] < roleLevels[role] {
+			err = errUnauthorized
+		}
+		switch err {
+		case nil:
+			next(w, r)
+		case errUnauthorized:
+			http.Error(w, fmt.Sprintf("Requires role %s", role), http.StatusForbidden)
+		default:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	}
+}
+
+// Identify tells who sent the request: the id of the client when it authenticated, otherwise the value of the header
+// when it is given and sent along, otherwise the IP address it came from.
+// The header is not authenticated, pass "" where the identity must not be chosen by the caller.
+func (s *Service) Identify(r *http.Request, header string) string {
+	if client, err := s.authenticate(r); err == nil {
+		return "client:" + client.ID
+	}
+	if value := r.Header.Get(header); header != "" && value != "" {
+		return "header:" + value
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func (s *Service) authenticate(r *http.Request) (Client, error) {
+	authorization := r.Header.Get("Authorization")
+	if key := strings.TrimPrefix(authorization, "Bearer "); key != authorization {
+		for _, client := range s.clients {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(client.Secret)) == 1 {
+				return client, nil
+			}
+		}
+		return Client{}, errUnauthenticated
+	}
+	if signed := strings.TrimPrefix(authorization, "HMAC "); signed != authorization {
+		return s.verifySignature(r, signed)
+	}
+	return Client{}, errUnauthenticated
+}
+
+func (s *Service) verifySignature(r *http.Request, signed string) (Client, error) {
+	parts := strings.SplitN(signed, ":", 2)
+	if len(parts) != 2 {
+		return Client{}, errUnauthenticated
+	}
+	timestamp := r.Header.Get("X-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)) > MaxClockSkew || time.Until(time.Unix(seconds, 0)) > MaxClockSkew {
+		return Client{}, errUnauthenticated
+	}
+	signature, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return Client{}, errUnauthenticated
+	}
+	for _, client := range s.clients {
+		if client.ID != parts[0] {
+			continue
+		}
+		expected, err := Sign(r, client.Secret, timestamp)
+		if err != nil {
+			return Client{}, err
+		}
+		if hmac.Equal(signature, expected) {
+			return client, nil
+		}
+	}
+	return Client{}, errUnauthenticated
+}
+
+// Sign returns the signature of a request, leaving its body readable.
+func Sign(r *http.Request, secret string, timestamp string) ([]byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", r.Method, r.URL.RequestURI(), timestamp, hex.EncodeToString(bodyHash[:]))
+	return mac.Sum(nil), nil
+}
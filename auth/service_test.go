@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"tbp.com/user/hello/repository"
+	"testing"
+)
+
+func TestSetupValidatesClients(t *testing.T) {
+	testCases := []struct {
+		name    string
+		clients []Client
+	}{
+		{"Rejects unknown roles", []Client{{ID: "dashboard", Secret: "secret", Role: "owner"}}},
+		{"Rejects clients without secret", []Client{{ID: "dashboard", Role: Reader}}},
+		{"Rejects duplicate ids", []Client{{ID: "dashboard", Secret: "one", Role: Reader}, {ID: "dashboard", Secret: "two", Role: Admin}}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := SetupWith(testCase.clients...); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestSetupReadsCredentials(t *testing.T) {
+	credentials := repository.InitializeMemory()
+	err := credentials.Persist(Credentials{Clients: []Client{{ID: "dashboard", Secret: "secret", Role: Reader}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := Setup(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(service.clients) != 1 || service.clients[0].ID != "dashboard" {
+		t.Errorf("Expected the dashboard client, but got %+v", service.clients)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	service, err := SetupWith(Client{ID: "dashboard", Secret: "secret", Role: Reader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		authorization string
+		clientID      string
+		expected      string
+	}{
+		{"Bearer secret", "alice", "client:dashboard"},
+		{"Bearer guessed", "alice", "header:alice"},
+		{"Bearer guessed", "", "ip:192.0.2.1"},
+		{"", "", "ip:192.0.2.1"},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, "/primes/4", nil)
+		request.Header.Set("Authorization", testCase.authorization)
+		request.Header.Set("X-Client-ID", testCase.clientID)
+		if actual := service.Identify(request, "X-Client-ID"); actual != testCase.expected {
+			t.Errorf("Expected %q for %q and %q, but got %q", testCase.expected, testCase.authorization, testCase.clientID, actual)
+		}
+	}
+	request := httptest.NewRequest(http.MethodGet, "/primes/4", nil)
+	request.Header.Set("X-Client-ID", "alice")
+	if actual := service.Identify(request, ""); actual != "ip:192.0.2.1" {
+		t.Errorf("Expected the header to be ignored when it isn't configured, but got %q", actual)
+	}
+}
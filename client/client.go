@@ -0,0 +1,252 @@
+// Package client calls the prime service from Go, returning the same responses types the service sends.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"tbp.com/user/hello/auth"
+	"tbp.com/user/hello/responses"
+	"time"
+)
+
+// Client calls the service at BaseURL. Its fields can be changed after New, but not while it is in use.
+type Client struct {
+	BaseURL string
+	// HTTP does the requests, its Timeout applies to every attempt
+	HTTP *http.Client
+	// Secret authenticates as API key, or signs requests when ID is set as well
+	ID     string
+	Secret string
+	// Retries is how often a request is tried again after a network error, a 429 or a 502, 503 or 504.
+	// It waits Backoff before the first retry and twice as long before every next one, unless the service says how long in Retry-After.
+	// Requests that count in the history are only tried again after a 429 or 503, as otherwise they may have been counted already.
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// StatusError is returned when the service doesn't answer with a 2xx status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+		Retries:    3,
+		Backoff:    100 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+	}
+}
+
+// HistoryFilter holds the query parameters of the history, the zero value leaves them out.
+type HistoryFilter struct {
+	From   *big.Int
+	To     *big.Int
+	Client string
+	Since  time.Time
+	Until  time.Time
+	// SortBy is "number" or "count"
+	SortBy     string
+	Descending bool
+}
+
+func (f HistoryFilter) query() url.Values {
+	query := url.Values{}
+	if f.From != nil {
+		query.Set("from", f.From.String())
+	}
+	if f.To != nil {
+		query.Set("to", f.To.String())
+	}
+	if f.Client != "" {
+		query.Set("client", f.Client)
+	}
+	if !f.Since.IsZero() {
+		query.Set("since", f.Since.Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		query.Set("until", f.Until.Format(time.RFC3339))
+	}
+	if f.SortBy != "" {
+		query.Set("sort", f.SortBy)
+	}
+	if f.Descending {
+		query.Set("order", "desc")
+	}
+	return query
+}
+
+// IsPrime asks whether the number is prime, which counts as a request for it in the history.
+func (c *Client) IsPrime(ctx context.Context, n *big.Int) (responses.Primes, error) {
+	var response responses.Primes
+	err := c.do(ctx, http.MethodGet, "/primes/"+n.String(), nil, nil, true, &response)
+	return response, err
+}
+
+// Batch asks whether all the numbers are prime at once, which takes the secret of a reader.
+// Whether that counts in the history depends on the service.
+func (c *Client) Batch(ctx context.Context, numbers []*big.Int) ([]responses.BatchPrimes, error) {
+	request := make([]json.Number, len(numbers))
+	for i, number := range numbers {
+		request[i] = json.Number(number.String())
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var response responses.Batch
+	err = c.do(ctx, http.MethodPost, "/primes/batch", nil, body, true, &response)
+	return response.Results, err
+}
+
+func (c *Client) Factors(ctx context.Context, n *big.Int) (responses.Factors, error) {
+	var response responses.Factors
+	err := c.do(ctx, http.MethodGet, "/primes/"+n.String()+"/factors", nil, nil, false, &response)
+	return response, err
+}
+
+// Range returns a page of at most limit primes from up to and including to. The next page starts from its Next.
+func (c *Client) Range(ctx context.Context, from int, to int, limit int) (responses.PrimeRange, error) {
+	var response responses.PrimeRange
+	query := url.Values{"from": {strconv.Itoa(from)}, "to": {strconv.Itoa(to)}, "limit": {strconv.Itoa(limit)}}
+	err := c.do(ctx, http.MethodGet, "/primes", query, nil, false, &response)
+	return response, err
+}
+
+func (c *Client) History(ctx context.Context, filter HistoryFilter) (responses.History, error) {
+	var response responses.History
+	err := c.do(ctx, http.MethodGet, "/history", filter.query(), nil, false, &response)
+	return response, err
+}
+
+func (c *Client) Messages(ctx context.Context) (responses.Messages, error) {
+	var response responses.Messages
+	err := c.do(ctx, http.MethodGet, "/messages", nil, nil, false, &response)
+	return response, err
+}
+
+// UpdateMessages replaces the feedback messages of the kinds it holds, the other kinds are left as they are.
+func (c *Client) UpdateMessages(ctx context.Context, messages responses.Messages) error {
+	body, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, "/messages", nil, body, false, nil)
+}
+
+// do decodes the response into response unless it is nil. A request that counts in the history is only retried
+// when the service refused it, as asking for a number again would count it once more.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body []byte, counts bool, response interface{}) error {
+	address := c.BaseURL + path
+	if len(query) > 0 {
+		address += "?" + query.Encode()
+	}
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		wait, err := c.try(ctx, method, address, body, response)
+		if err == nil || wait < 0 || attempt >= c.Retries || ctx.Err() != nil || (counts && !refused(err)) {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+			if backoff *= 2; c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+				backoff = c.MaxBackoff
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// refused tells whether the service turned the request away before counting it: it was rate limited, or not ready yet.
+// After a network error or a failing gateway it may have been counted already.
+func refused(err error) bool {
+	var statusError *StatusError
+	return errors.As(err, &statusError) && (statusError.StatusCode == http.StatusTooManyRequests || statusError.StatusCode == http.StatusServiceUnavailable)
+}
+
+// try does a single attempt. When it fails it returns how long to wait before trying again:
+// 0 for the regular backoff, or less than 0 when trying again won't help.
+func (c *Client) try(ctx context.Context, method string, address string, body []byte, response interface{}) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, address, reader)
+	if err != nil {
+		return -1, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if err := c.authenticate(request); err != nil {
+		return -1, err
+	}
+	received, err := c.HTTP.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer received.Body.Close()
+	if received.StatusCode < 200 || received.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(received.Body)
+		err := &StatusError{StatusCode: received.StatusCode, Message: strings.TrimSpace(string(message))}
+		switch received.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			if seconds, parseErr := strconv.Atoi(received.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second, err
+			}
+			return 0, err
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			return 0, err
+		}
+		return -1, err
+	}
+	if response == nil {
+		return 0, nil
+	}
+	if err := json.NewDecoder(received.Body).Decode(response); err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+func (c *Client) authenticate(request *http.Request) error {
+	if c.Secret == "" {
+		return nil
+	}
+	if c.ID == "" {
+		request.Header.Set("Authorization", "Bearer "+c.Secret)
+		return nil
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := auth.Sign(request, c.Secret, timestamp)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Timestamp", timestamp)
+	request.Header.Set("Authorization", "HMAC "+c.ID+":"+hex.EncodeToString(signature))
+	return nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetriesWithBackoff(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			http.Error(w, "Not ready yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"isPrime":true,"message":"It is prime. Hurray!","verdict":"prime","certainty":1}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	c.Backoff = time.Millisecond
+	primes, err := c.IsPrime(context.Background(), big.NewInt(7))
+	if err != nil || !primes.IsPrime || attempts != 3 {
+		t.Errorf("Expected 7 to be prime after 3 attempts, but got %+v after %d: %v", primes, attempts, err)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	c.Retries = 1
+	var statusError *StatusError
+	if _, err := c.IsPrime(context.Background(), big.NewInt(7)); !errors.As(err, &statusError) || statusError.StatusCode != http.StatusServiceUnavailable || attempts != 2 {
+		t.Errorf("Expected to give up after 2 attempts, but got %d: %v", attempts, err)
+	}
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "Not a positive integer", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).IsPrime(context.Background(), big.NewInt(-7))
+	var statusError *StatusError
+	if !errors.As(err, &statusError) || statusError.Message != "Not a positive integer" || attempts != 1 {
+		t.Errorf("Expected a single bad request, but got %d: %v", attempts, err)
+	}
+}
+
+func TestRetriesCountedRequestsOnlyWhenRefused(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		// Drops the connection, so the client can't tell whether the request was handled
+		panic(http.ErrAbortHandler)
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	c.Backoff = time.Millisecond
+	if _, err := c.IsPrime(context.Background(), big.NewInt(7)); err == nil || atomic.LoadInt32(&attempts) != 1 {
+		t.Errorf("Expected a single attempt at a request that counts, but got %d: %v", atomic.LoadInt32(&attempts), err)
+	}
+	atomic.StoreInt32(&attempts, 0)
+	if _, err := c.Factors(context.Background(), big.NewInt(7)); err == nil || atomic.LoadInt32(&attempts) != 4 {
+		t.Errorf("Expected 4 attempts at a request that doesn't count, but got %d: %v", atomic.LoadInt32(&attempts), err)
+	}
+}
+
+func TestStopsWaitingWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Slow down!", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := New(server.URL).IsPrime(ctx, big.NewInt(7))
+	var statusError *StatusError
+	if !errors.As(err, &statusError) || statusError.StatusCode != http.StatusTooManyRequests || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected to stop waiting for Retry-After once cancelled, but got %v after %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"tbp.com/user/hello/client"
+	"tbp.com/user/hello/responses"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	anonymous := client.New(server.URL)
+	primes, err := anonymous.IsPrime(ctx, big.NewInt(9002))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primes.IsPrime || primes.SmallestFactor != "2" {
+		t.Errorf("Expected 9002 to be divisible by 2, but got %+v", primes)
+	}
+	factors, err := anonymous.Factors(ctx, big.NewInt(12))
+	if err != nil || len(factors.Factors) != 2 || !factors.Complete {
+		t.Errorf("Expected 12 to have 2 prime factors, but got %+v: %v", factors, err)
+	}
+	primeRange, err := anonymous.Range(ctx, 0, 10, 2)
+	if err != nil || len(primeRange.Primes) != 2 || primeRange.Next == nil || *primeRange.Next != 4 {
+		t.Errorf("Expected 2 and 3 and the next page to start at 4, but got %+v: %v", primeRange, err)
+	}
+	var statusError *client.StatusError
+	if _, err := anonymous.Batch(ctx, []*big.Int{big.NewInt(7)}); !errors.As(err, &statusError) || statusError.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a batch to be unauthorized without a secret, but got %v", err)
+	}
+	if err := anonymous.UpdateMessages(ctx, responses.Messages{}); !errors.As(err, &statusError) || statusError.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected to be unauthorized to change messages without a secret, but got %v", err)
+	}
+
+	dashboard := client.New(server.URL)
+	dashboard.Secret = reader.Secret
+	batch, err := dashboard.Batch(ctx, []*big.Int{big.NewInt(7), big.NewInt(9002)})
+	if err != nil || len(batch) != 2 || !batch[0].IsPrime || batch[1].IsPrime {
+		t.Errorf("Expected only 7 to be prime, but got %+v: %v", batch, err)
+	}
+	history, err := dashboard.History(ctx, client.HistoryFilter{From: big.NewInt(9000), SortBy: "count", Descending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Requests) != 1 || history.Requests[0].Number != "9002" || history.Requests[0].Count != 1 {
+		t.Errorf("Expected 9002 to be asked for once, but got %+v", history.Requests)
+	}
+
+	// The editor signs its requests instead of sending its secret
+	copywriter := client.New(server.URL)
+	copywriter.ID, copywriter.Secret = editor.ID, editor.Secret
+	update := responses.Messages{Messages: responses.MessageSlice{
+		{LowerLimit: 0, Message: "Nope", Kind: "notPrime"},
+		{LowerLimit: 0, Message: "Yes", Kind: "prime"},
+	}}
+	if err := copywriter.UpdateMessages(ctx, update); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := copywriter.Messages(ctx)
+	if err != nil || len(messages.Messages) != 2 || messages.Messages[0].Message != "Nope" {
+		t.Errorf("Expected the updated messages, but got %+v: %v", messages, err)
+	}
+	if err := dashboard.UpdateMessages(ctx, update); !errors.As(err, &statusError) || statusError.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a reader not to be allowed to update messages, but got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+// Command primectl asks the prime service whether numbers are prime, and manages its history and feedback messages.
+//
+//	primectl [-url http://localhost:8080] [-secret <secret>] [-output json|table|csv] <command> [arguments]
+//
+// Commands:
+//
+//	check [number...]         whether the numbers are prime, read from stdin when there are none
+//	factor [number...]        the prime factors of the numbers, read from stdin when there are none
+//	range [-limit n] from to  the primes from up to and including to
+//	history [-from n] [-to n] [-sort number|count] [-order asc|desc] [-client c] [-since t] [-until t]
+//	messages get              the feedback messages
+//	messages set [file]       replaces the feedback messages by those in the JSON file, or stdin
+//
+// The URL and secret can also be given with PRIMECTL_URL and PRIMECTL_SECRET.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"tbp.com/user/hello/client"
+	"tbp.com/user/hello/responses"
+	"time"
+)
+
+const environmentPrefix = "PRIMECTL_"
+
+// errFailed tells that some of the numbers failed, which was already reported for each of them.
+var errFailed = errors.New("failed for some numbers")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv))
+}
+
+// command runs with the arguments after its name, and prints its results.
+type command func(c *client.Client, arguments []string, stdin io.Reader, out *printer, stderr io.Writer) error
+
+var commands = map[string]command{
+	"check":    check,
+	"factor":   factor,
+	"range":    primeRange,
+	"history":  history,
+	"messages": feedbackMessages,
+}
+
+func run(arguments []string, stdin io.Reader, stdout io.Writer, stderr io.Writer, getenv func(string) string) int {
+	flags := flag.NewFlagSet("primectl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	baseURL := flags.String("url", orDefault(getenv(environmentPrefix+"URL"), "http://localhost:8080"), "URL of the service (env PRIMECTL_URL)")
+	secret := flags.String("secret", getenv(environmentPrefix+"SECRET"), "secret to authenticate with (env PRIMECTL_SECRET)")
+	format := flags.String("output", formatTable, fmt.Sprintf("output format: %q, %q or %q", formatJSON, formatTable, formatCSV))
+	timeout := flags.Duration("timeout", 10*time.Second, "how long a request may take")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: primectl [flags] check|factor|range|history|messages [arguments]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(arguments); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || commands[flags.Arg(0)] == nil {
+		flags.Usage()
+		return 2
+	}
+	out, err := newPrinter(*format, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	c := client.New(*baseURL)
+	c.Secret = *secret
+	c.HTTP.Timeout = *timeout
+	err = commands[flags.Arg(0)](c, flags.Args()[1:], stdin, out, stderr)
+	if flushErr := out.flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		if err != errFailed {
+			fmt.Fprintln(stderr, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+func check(c *client.Client, arguments []string, stdin io.Reader, out *printer, stderr io.Writer) error {
+	return eachNumber(arguments, stdin, stderr, func(number *big.Int) error {
+		primes, err := c.IsPrime(context.Background(), number)
+		if err != nil {
+			return fmt.Errorf("%s: %v", number, err)
+		}
+		return out.print(responses.BatchPrimes{Number: json.Number(number.String()), Primes: &primes},
+			[]string{"number", "prime", "verdict", "message", "smallestFactor"},
+			[]string{number.String(), strconv.FormatBool(primes.IsPrime), primes.Verdict, primes.Message, primes.SmallestFactor.String()})
+	})
+}
+
+func factor(c *client.Client, arguments []string, stdin io.Reader, out *printer, stderr io.Writer) error {
+	return eachNumber(arguments, stdin, stderr, func(number *big.Int) error {
+		factors, err := c.Factors(context.Background(), number)
+		if err != nil {
+			return fmt.Errorf("%s: %v", number, err)
+		}
+		var written []string
+		for _, f := range factors.Factors {
+			if f.Exponent == 1 {
+				written = append(written, f.Prime.String())
+			} else {
+				written = append(written, fmt.Sprintf("%s^%d", f.Prime, f.Exponent))
+			}
+		}
+		return out.print(factors,
+			[]string{"number", "factors", "complete", "remainder"},
+			[]string{factors.Number.String(), strings.Join(written, " "), strconv.FormatBool(factors.Complete), factors.Remainder.String()})
+	})
+}
+
+// eachNumber goes through the numbers in the arguments, or on stdin when there are none, separated by white space.
+// A number that fails is reported, after which it goes on with the next one.
+func eachNumber(arguments []string, stdin io.Reader, stderr io.Writer, do func(number *big.Int) error) error {
+	failed := false
+	handle := func(number string) {
+		parsed, ok := new(big.Int).SetString(number, 10)
+		if !ok || parsed.Sign() < 0 {
+			fmt.Fprintf(stderr, "not a positive integer: %s\n", number)
+			failed = true
+			return
+		}
+		if err := do(parsed); err != nil {
+			fmt.Fprintln(stderr, err)
+			failed = true
+		}
+	}
+	if len(arguments) > 0 {
+		for _, number := range arguments {
+			handle(number)
+		}
+	} else {
+		scanner := bufio.NewScanner(stdin)
+		scanner.Split(bufio.ScanWords)
+		for scanner.Scan() {
+			handle(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+// primeRange follows the pages of the range, so in JSON every page is a line.
+func primeRange(c *client.Client, arguments []string, _ io.Reader, out *printer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("range", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	limit := flags.Int("limit", 1000, "primes per request")
+	if err := flags.Parse(arguments); err != nil {
+		return err
+	}
+	usage := errors.New("usage: primectl range [-limit n] from to")
+	if flags.NArg() != 2 {
+		return usage
+	}
+	from, err := strconv.Atoi(flags.Arg(0))
+	if err != nil {
+		return usage
+	}
+	to, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return usage
+	}
+	for {
+		page, err := c.Range(context.Background(), from, to, *limit)
+		if err != nil {
+			return err
+		}
+		var rows [][]string
+		for _, prime := range page.Primes {
+			rows = append(rows, []string{strconv.Itoa(prime)})
+		}
+		if err := out.print(page, []string{"prime"}, rows...); err != nil {
+			return err
+		}
+		if page.Next == nil {
+			return nil
+		}
+		from = *page.Next
+	}
+}
+
+func history(c *client.Client, arguments []string, _ io.Reader, out *printer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var filter client.HistoryFilter
+	flags.Func("from", "smallest number", numberFlag(&filter.From))
+	flags.Func("to", "largest number", numberFlag(&filter.To))
+	flags.StringVar(&filter.Client, "client", "", "only count the requests of this client, like ip:127.0.0.1")
+	flags.Func("since", "only count requests since this time, like 2021-03-01T12:00:00Z", timeFlag(&filter.Since))
+	flags.Func("until", "only count requests until this time", timeFlag(&filter.Until))
+	flags.StringVar(&filter.SortBy, "sort", "", "sort by number or count")
+	order := flags.String("order", "asc", "asc or desc")
+	if err := flags.Parse(arguments); err != nil {
+		return err
+	}
+	filter.Descending = *order == "desc"
+	response, err := c.History(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, request := range response.Requests {
+		rows = append(rows, []string{request.Number.String(), strconv.Itoa(request.Count), strconv.FormatBool(request.IsPrime), formatTime(request.FirstSeen), formatTime(request.LastSeen)})
+	}
+	return out.print(response, []string{"number", "count", "prime", "firstSeen", "lastSeen"}, rows...)
+}
+
+func numberFlag(number **big.Int) func(string) error {
+	return func(value string) error {
+		parsed, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return fmt.Errorf("not an integer: %s", value)
+		}
+		*number = parsed
+		return nil
+	}
+}
+
+func timeFlag(t *time.Time) func(string) error {
+	return func(value string) (err error) {
+		*t, err = time.Parse(time.RFC3339, value)
+		return err
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func feedbackMessages(c *client.Client, arguments []string, stdin io.Reader, out *printer, _ io.Writer) error {
+	usage := errors.New("usage: primectl messages get|set [file]")
+	if len(arguments) == 0 {
+		return usage
+	}
+	switch arguments[0] {
+	case "get":
+		response, err := c.Messages(context.Background())
+		if err != nil {
+			return err
+		}
+		var rows [][]string
+		for _, message := range response.Messages {
+			rows = append(rows, []string{message.Kind, strconv.Itoa(message.LowerLimit), message.Message})
+		}
+		return out.print(response, []string{"kind", "lowerLimit", "message"}, rows...)
+	case "set":
+		source := stdin
+		if len(arguments) > 1 && arguments[1] != "-" {
+			file, err := os.Open(arguments[1])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			source = file
+		}
+		var request responses.Messages
+		if err := json.NewDecoder(source).Decode(&request); err != nil {
+			return fmt.Errorf("can't read messages like %s: %v", `{"messages":[{"lowerLimit":0,"message":"No","kind":"notPrime"}]}`, err)
+		}
+		return c.UpdateMessages(context.Background(), request)
+	}
+	return usage
+}
+
+func orDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
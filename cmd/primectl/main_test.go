@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tbp.com/user/hello/responses"
+	"testing"
+)
+
+func setupService(t *testing.T) (*httptest.Server, *responses.Messages) {
+	posted := &responses.Messages{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/primes/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/primes/7":
+			json.NewEncoder(w).Encode(responses.Primes{IsPrime: true, Message: "It is prime. Hurray!", Verdict: "prime", Certainty: 1})
+		case "/primes/9":
+			json.NewEncoder(w).Encode(responses.Primes{Message: "No", Verdict: "not prime", Certainty: 1, SmallestFactor: "3"})
+		case "/primes/12/factors":
+			json.NewEncoder(w).Encode(responses.Factors{Number: "12", Factors: []responses.Factor{{Prime: "2", Exponent: 2}, {Prime: "3", Exponent: 1}}, Complete: true})
+		default:
+			http.Error(w, "Not a positive integer", http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/primes", func(w http.ResponseWriter, r *http.Request) {
+		next := 6
+		if r.URL.Query().Get("from") == "0" {
+			json.NewEncoder(w).Encode(responses.PrimeRange{From: 0, To: 10, Primes: []int{2, 3, 5}, Next: &next})
+		} else {
+			json.NewEncoder(w).Encode(responses.PrimeRange{From: 6, To: 10, Primes: []int{7}})
+		}
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(posted)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		json.NewEncoder(w).Encode(responses.Messages{Messages: responses.MessageSlice{{LowerLimit: 0, Message: "No", Kind: "notPrime"}}})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, posted
+}
+
+func runWith(t *testing.T, server *httptest.Server, stdin string, arguments ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	environment := map[string]string{"PRIMECTL_URL": server.URL, "PRIMECTL_SECRET": "secret"}
+	code := run(arguments, strings.NewReader(stdin), &stdout, &stderr, func(name string) string { return environment[name] })
+	return code, stdout.String(), stderr.String()
+}
+
+func TestOutputFormats(t *testing.T) {
+	server, _ := setupService(t)
+	testCases := []struct {
+		name      string
+		arguments []string
+		stdin     string
+		expected  string
+	}{
+		{"Checks numbers as CSV", []string{"-output", "csv", "check", "7", "9"}, "",
+			"number,prime,verdict,message,smallestFactor\n7,true,prime,It is prime. Hurray!,\n9,false,not prime,No,3\n"},
+		{"Checks numbers from stdin as JSON", []string{"-output", "json", "check"}, "7\n9",
+			`{"number":7,"isPrime":true,"message":"It is prime. Hurray!","verdict":"prime","certainty":1}` + "\n" +
+				`{"number":9,"isPrime":false,"message":"No","verdict":"not prime","certainty":1,"smallestFactor":3}` + "\n"},
+		{"Factors numbers as a table", []string{"factor", "12"}, "",
+			"number  factors  complete  remainder\n12      2^2 3    true      \n"},
+		{"Follows the pages of a range", []string{"-output", "csv", "range", "0", "10"}, "",
+			"prime\n2\n3\n5\n7\n"},
+		{"Gets messages with the secret", []string{"-output", "csv", "messages", "get"}, "",
+			"kind,lowerLimit,message\nnotPrime,0,No\n"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			code, stdout, stderr := runWith(t, server, testCase.stdin, testCase.arguments...)
+			if code != 0 || stdout != testCase.expected {
+				t.Errorf("Expected exit code 0 and\n%s\nbut got %d and\n%s\n%s", testCase.expected, code, stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestBulkCheckGoesOnAfterAFailure(t *testing.T) {
+	server, _ := setupService(t)
+	code, stdout, stderr := runWith(t, server, "seven 8 7", "-output", "csv", "check")
+	if code != 1 || !strings.Contains(stderr, "not a positive integer: seven") || !strings.Contains(stderr, "8: 400 Bad Request: Not a positive integer") || !strings.HasSuffix(stdout, "7,true,prime,It is prime. Hurray!,\n") {
+		t.Errorf("Expected 7 to be checked after seven and 8 failed, but got exit code %d,\n%s\n%s", code, stdout, stderr)
+	}
+}
+
+func TestSetMessages(t *testing.T) {
+	server, posted := setupService(t)
+	file := t.TempDir() + "/messages.json"
+	if err := ioutil.WriteFile(file, []byte(`{"messages":[{"lowerLimit":0,"message":"Nope","kind":"notPrime"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, _, stderr := runWith(t, server, "", "messages", "set", file)
+	if code != 0 || len(posted.Messages) != 1 || posted.Messages[0].Message != "Nope" {
+		t.Errorf("Expected the messages to be posted, but got exit code %d and %+v: %s", code, posted, stderr)
+	}
+}
+
+func TestRejectsUnknownCommandsAndFormats(t *testing.T) {
+	server, _ := setupService(t)
+	for _, arguments := range [][]string{{"prime", "7"}, {"-output", "xml", "check", "7"}, {}} {
+		if code, _, _ := runWith(t, server, "", arguments...); code != 2 {
+			t.Errorf("Expected exit code 2 for %v, but got %d", arguments, code)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats
+const (
+	formatJSON  = "json"
+	formatTable = "table"
+	formatCSV   = "csv"
+)
+
+// printer writes every result as it comes in. JSON is written as a value per line, tables and CSV as rows under a single header.
+type printer struct {
+	format  string
+	json    *json.Encoder
+	table   *tabwriter.Writer
+	csv     *csv.Writer
+	headers bool
+}
+
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	p := &printer{format: format}
+	switch format {
+	case formatJSON:
+		p.json = json.NewEncoder(w)
+	case formatTable:
+		p.table = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	case formatCSV:
+		p.csv = csv.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("output must be %q, %q or %q, not %q", formatJSON, formatTable, formatCSV, format)
+	}
+	return p, nil
+}
+
+func (p *printer) print(value interface{}, columns []string, rows ...[]string) error {
+	if p.json != nil {
+		return p.json.Encode(value)
+	}
+	if !p.headers {
+		p.headers = true
+		rows = append([][]string{columns}, rows...)
+	}
+	for _, row := range rows {
+		if p.csv != nil {
+			if err := p.csv.Write(row); err != nil {
+				return err
+			}
+		} else if _, err := fmt.Fprintln(p.table, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *printer) flush() error {
+	if p.csv != nil {
+		p.csv.Flush()
+		return p.csv.Error()
+	}
+	if p.table != nil {
+		return p.table.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"tbp.com/user/hello/repository"
+	"time"
+)
+
+const EnvironmentPrefix = "HELLO_"
+
+// Which count of requests for a number picks the feedback message
+const (
+	CountGlobal = "global"
+	CountClient = "client"
+)
+
+type Config struct {
+	Address    string `json:"address"`
+	DataFolder string `json:"dataFolder"`
+	Storage    string `json:"storage"`
+	ServerLog  string `json:"serverLog"`
+	HttpLog    string `json:"httpLog"`
+	// LogMaxSize is in megabytes
+	LogMaxSize        int      `json:"logMaxSize"`
+	LogRotateInterval Duration `json:"logRotateInterval"`
+	LogRetain         int      `json:"logRetain"`
+	// Rate limits are in requests per minute per client, the repeat limit is for every number it asks for
+	RateLimit            int    `json:"rateLimit"`
+	RateLimitBurst       int    `json:"rateLimitBurst"`
+	RepeatRateLimit      int    `json:"repeatRateLimit"`
+	RepeatRateLimitBurst int    `json:"repeatRateLimitBurst"`
+	RateLimitMessage     string `json:"rateLimitMessage"`
+	// ClientHeader identifies clients that didn't authenticate, instead of their IP address
+	ClientHeader string `json:"clientHeader"`
+	MessageCount string `json:"messageCount"`
+	// MessageWindow only counts requests within that time for the feedback message, 0 counts all of them
+	MessageWindow Duration `json:"messageWindow"`
+	// History retention, 0 turns that part off
+	HistoryMaxAge            Duration `json:"historyMaxAge"`
+	HistoryMaxNumbers        int      `json:"historyMaxNumbers"`
+	HistoryHalfLife          Duration `json:"historyHalfLife"`
+	HistoryRetentionInterval Duration `json:"historyRetentionInterval"`
+	// BatchLimit is how many numbers a batch can hold, BatchCountsHistory whether they count as requests in the history
+	BatchLimit         int  `json:"batchLimit"`
+	BatchCountsHistory bool `json:"batchCountsHistory"`
+}
+
+func Default() Config {
+	return Config{
+		Address:                  ":8080",
+		DataFolder:               "data",
+		Storage:                  repository.File,
+		ServerLog:                "logs/server.log",
+		HttpLog:                  "logs/http.log",
+		LogMaxSize:               100,
+		LogRotateInterval:        Duration(24 * time.Hour),
+		LogRetain:                7,
+		RateLimit:                600,
+		RateLimitBurst:           60,
+		RepeatRateLimit:          6,
+		RepeatRateLimitBurst:     5,
+		RateLimitMessage:         "Slow down! You've asked too often, try again later.",
+		MessageCount:             CountGlobal,
+		HistoryRetentionInterval: Duration(time.Hour),
+		BatchLimit:               10000,
+	}
+}
+
+type setting struct {
+	name        string
+	value       flag.Value
+	description string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"address", (*stringValue)(&c.Address), "address to listen on"},
+		{"data-folder", (*stringValue)(&c.DataFolder), "folder to store data in"},
+		{"storage", (*stringValue)(&c.Storage), fmt.Sprintf("where to store data: %q, %q or %q", repository.File, repository.Bolt, repository.Memory)},
+		{"server-log", (*stringValue)(&c.ServerLog), "file to write server logs to"},
+		{"http-log", (*stringValue)(&c.HttpLog), "file to write access logs to"},
+		{"log-max-size", (*intValue)(&c.LogMaxSize), "megabytes a log file can grow to before it is rotated, 0 to never rotate by size"},
+		{"log-rotate-interval", &c.LogRotateInterval, "how often log files are rotated, like 24h, 0 to never rotate by time"},
+		{"log-retain", (*intValue)(&c.LogRetain), "number of rotated log files to keep, 0 to keep all of them"},
+		{"rate-limit", (*intValue)(&c.RateLimit), "requests per minute a client can ask whether numbers are prime, 0 for no limit"},
+		{"rate-limit-burst", (*intValue)(&c.RateLimitBurst), "requests a client can make at once before the rate limit applies"},
+		{"repeat-rate-limit", (*intValue)(&c.RepeatRateLimit), "requests per minute a client can ask for the same number, 0 for no limit"},
+		{"repeat-rate-limit-burst", (*intValue)(&c.RepeatRateLimitBurst), "requests for the same number a client can make at once before the repeat rate limit applies"},
+		{"rate-limit-message", (*stringValue)(&c.RateLimitMessage), "message for clients that hit a rate limit"},
+		{"client-header", (*optionalStringValue)(&c.ClientHeader), "header that identifies clients that didn't authenticate, instead of their IP address"},
+		{"message-count", (*stringValue)(&c.MessageCount), fmt.Sprintf("which count of requests for a number picks the feedback message: %q or %q", CountGlobal, CountClient)},
+		{"message-window", &c.MessageWindow, "only count requests within this time for the feedback message, like 24h, 0 to count all of them"},
+		{"history-max-age", &c.HistoryMaxAge, "forget numbers that were not asked for within this time, like 720h, 0 to keep them"},
+		{"history-max-numbers", (*intValue)(&c.HistoryMaxNumbers), "numbers to remember at most, the ones asked for least recently are forgotten first, 0 for no limit"},
+		{"history-half-life", &c.HistoryHalfLife, "time in which the counts of the history halve, like 168h, 0 to never decay them"},
+		{"history-retention-interval", &c.HistoryRetentionInterval, "how often the history retention is applied, 0 to never apply it"},
+		{"batch-limit", (*intValue)(&c.BatchLimit), "numbers a batch can hold at most"},
+		{"batch-counts-history", (*boolValue)(&c.BatchCountsHistory), "whether numbers in a batch count as requests in the history"},
+	}
+}
+
+// Load starts from the defaults, which are overridden by the config file, then by environment variables
+// and finally by command-line flags. The config file is given with -config or HELLO_CONFIG.
+func Load(arguments []string, getenv func(string) string) (Config, error) {
+	config := Default()
+	var fromFlags Config
+	flags := flag.NewFlagSet("hello", flag.ContinueOnError)
+	configFile := flags.String("config", getenv(EnvironmentPrefix+"CONFIG"), "JSON file to read the configuration from")
+	for _, s := range fromFlags.settings() {
+		flags.Var(s.value, s.name, fmt.Sprintf("%s (env %s, default %q)", s.description, environmentVariable(s.name), config.setting(s.name).value))
+	}
+	if err := flags.Parse(arguments); err != nil {
+		return config, err
+	}
+
+	if *configFile != "" {
+		bytes, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return config, err
+		}
+		if err := json.Unmarshal(bytes, &config); err != nil {
+			return config, fmt.Errorf("can't read config file %s: %v", *configFile, err)
+		}
+	}
+	for _, s := range config.settings() {
+		if value := getenv(environmentVariable(s.name)); value != "" {
+			if err := s.value.Set(value); err != nil {
+				return config, fmt.Errorf("invalid value %q for %s: %v", value, environmentVariable(s.name), err)
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if s := config.setting(f.Name); s != nil {
+			s.value.Set(f.Value.String())
+		}
+	})
+	return config, config.Validate()
+}
+
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("address must be host:port or :port, not %q: %v", c.Address, err)
+	}
+	if c.Storage != repository.File && c.Storage != repository.Bolt && c.Storage != repository.Memory {
+		return fmt.Errorf("storage must be %q, %q or %q, not %q", repository.File, repository.Bolt, repository.Memory, c.Storage)
+	}
+	if c.MessageCount != CountGlobal && c.MessageCount != CountClient {
+		return fmt.Errorf("message-count must be %q or %q, not %q", CountGlobal, CountClient, c.MessageCount)
+	}
+	if c.BatchLimit < 1 {
+		return fmt.Errorf("batch-limit must be at least 1, not %d", c.BatchLimit)
+	}
+	for _, s := range c.settings() {
+		if _, optional := s.value.(*optionalStringValue); !optional && strings.TrimSpace(s.value.String()) == "" {
+			return fmt.Errorf("%s must not be empty", s.name)
+		}
+		if strings.HasPrefix(s.value.String(), "-") {
+			if _, ok := s.value.(*stringValue); !ok {
+				return fmt.Errorf("%s must not be negative", s.name)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) setting(name string) *setting {
+	for _, s := range c.settings() {
+		if s.name == name {
+			return &s
+		}
+	}
+	return nil
+}
+
+func environmentVariable(name string) string {
+	return EnvironmentPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+type stringValue string
+
+func (s *stringValue) String() string {
+	return string(*s)
+}
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+// optionalStringValue is a string that may be empty
+type optionalStringValue string
+
+func (s *optionalStringValue) String() string {
+	return string(*s)
+}
+
+func (s *optionalStringValue) Set(value string) error {
+	*s = optionalStringValue(value)
+	return nil
+}
+
+type intValue int
+
+func (i *intValue) String() string {
+	return strconv.Itoa(int(*i))
+}
+
+func (i *intValue) Set(value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*i = intValue(parsed)
+	return nil
+}
+
+type boolValue bool
+
+func (b *boolValue) String() string {
+	return strconv.FormatBool(bool(*b))
+}
+
+func (b *boolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*b = boolValue(parsed)
+	return nil
+}
+
+// IsBoolFlag allows -batch-counts-history without a value
+func (b *boolValue) IsBoolFlag() bool {
+	return true
+}
+
+// Duration is written like 1h30m, in JSON as well.
+type Duration time.Duration
+
+func (d *Duration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.Set(value)
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	configFile := t.TempDir() + "/config.json"
+	err := ioutil.WriteFile(configFile, []byte(`{"address":":9000","dataFolder":"file-data","storage":"bolt"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	environment := map[string]string{
+		"HELLO_CONFIG":      configFile,
+		"HELLO_DATA_FOLDER": "env-data",
+		"HELLO_STORAGE":     "memory",
+	}
+	config, err := Load([]string{"-storage", "file"}, func(name string) string { return environment[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.Address = ":9000"
+	expected.DataFolder = "env-data"
+	expected.Storage = "file"
+	if config != expected {
+		t.Errorf("Expected %+v, but got %+v", expected, config)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	noEnvironment := func(string) string { return "" }
+	for _, arguments := range [][]string{
+		{"-storage", "tape"},
+		{"-address", "8080"},
+		{"-data-folder", " "},
+		{"-config", "does-not-exist.json"},
+		{"-log-max-size", "-1"},
+		{"-log-retain", "many"},
+		{"-log-rotate-interval", "daily"},
+		{"-history-max-numbers", "-10"},
+		{"-history-half-life", "-1h"},
+		{"-batch-limit", "0"},
+		{"-batch-counts-history=maybe", ""},
+	} {
+		t.Run("Rejects "+arguments[0]+" "+arguments[1], func(t *testing.T) {
+			if _, err := Load(arguments, noEnvironment); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadNumbersAndDurations(t *testing.T) {
+	configFile := t.TempDir() + "/config.json"
+	err := ioutil.WriteFile(configFile, []byte(`{"logMaxSize":5,"logRotateInterval":"1h30m"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	environment := map[string]string{
+		"HELLO_CONFIG":              configFile,
+		"HELLO_LOG_RETAIN":          "3",
+		"HELLO_HISTORY_MAX_NUMBERS": "1000",
+	}
+	config, err := Load([]string{"-log-max-size", "10", "-batch-counts-history"}, func(name string) string { return environment[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LogMaxSize != 10 || config.LogRotateInterval != Duration(90*time.Minute) || config.LogRetain != 3 || config.HistoryMaxNumbers != 1000 || !config.BatchCountsHistory {
+		t.Errorf("Expected 10 MB, 1h30m, 3, 1000 numbers and batches to count, but got %+v", config)
+	}
+	if _, err := Load(nil, func(name string) string { return map[string]string{"HELLO_LOG_RETAIN": "all"}[name] }); err == nil {
+		t.Error("Expected an error for an environment variable that is not a number")
+	}
+}
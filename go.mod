@@ -1,8 +1,9 @@
 module tbp.com/user/hello
 
-go 1.15
+go 1.16
 
 require (
-	github.com/gorilla/handlers v1.5.1
+	github.com/felixge/httpsnoop v1.0.1
 	github.com/gorilla/mux v1.8.0
+	go.etcd.io/bbolt v1.3.5
 )
@@ -0,0 +1,54 @@
+package history
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	SortByNumber = "number"
+	SortByCount  = "count"
+)
+
+// Filter selects which memories end up in a history response and in which order.
+// A nil bound means the range is open on that side. Only numbers with requests that count are included.
+type Filter struct {
+	From *big.Int
+	To   *big.Int
+	Counting
+	SortBy     string
+	Descending bool
+}
+
+func (f Filter) Validate() error {
+	if f.From != nil && f.To != nil && f.From.Cmp(f.To) > 0 {
+		return fmt.Errorf("from (%s) must not be greater than to (%s)", f.From, f.To)
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && f.Since.After(f.Until) {
+		return fmt.Errorf("since (%s) must not be after until (%s)", f.Since.Format(time.RFC3339), f.Until.Format(time.RFC3339))
+	}
+	if f.SortBy != SortByNumber && f.SortBy != SortByCount {
+		return fmt.Errorf("can only sort by %q or %q, not by %q", SortByNumber, SortByCount, f.SortBy)
+	}
+	return nil
+}
+
+func (f Filter) includes(number *big.Int) bool {
+	return (f.From == nil || number.Cmp(f.From) >= 0) && (f.To == nil || number.Cmp(f.To) <= 0)
+}
+
+func (f Filter) sort(entries []entry) {
+	less := func(i, j int) bool {
+		if f.SortBy == SortByCount && entries[i].count != entries[j].count {
+			return entries[i].count < entries[j].count
+		}
+		return entries[i].number.Cmp(entries[j].number) < 0
+	}
+	if f.Descending {
+		sort.Slice(entries, func(i, j int) bool { return less(j, i) })
+	} else {
+		sort.Slice(entries, less)
+	}
+}
@@ -1,55 +1,224 @@
 package history
 
 import (
+	"context"
+	"encoding/json"
+	"math/big"
 	"tbp.com/user/hello/messages"
 	"tbp.com/user/hello/primes"
 	"tbp.com/user/hello/responses"
+	"time"
 )
 
-type Memories map[int]*Memory
+// Memories are keyed by the decimal representation of the number, so they are not limited to int.
+type Memories map[string]*Memory
 
+// Memory counts the requests for a number by everyone, and by every client on its own.
+// Hours count the same requests per hour since the Unix epoch, so they can be counted within a period of time.
+// Memories from before requests were timestamped have no hours and are not seen.
 type Memory struct {
-	Count   int
-	IsPrime bool
+	Count       int
+	IsPrime     bool
+	Clients     map[string]int           `json:",omitempty"`
+	FirstSeen   time.Time                `json:",omitempty"`
+	LastSeen    time.Time                `json:",omitempty"`
+	Hours       map[int64]int            `json:",omitempty"`
+	ClientHours map[string]map[int64]int `json:",omitempty"`
+	// Decayed is when the counts last decayed, see Retention
+	Decayed time.Time `json:",omitempty"`
 }
 
-func (memories *Memories) Update(number int) {
+// Counting selects the requests for a number that count: those of the client, or of everyone when it is empty,
+// made since Since and until Until. A zero time leaves the period open on that side.
+type Counting struct {
+	Client string
+	Since  time.Time
+	Until  time.Time
+}
+
+// entry is what the history shows of a memory. It is copied, so it can be sent after the lock is released.
+type entry struct {
+	number    *big.Int
+	count     int
+	isPrime   bool
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+func (e entry) toRequest() responses.Request {
+	request := responses.Request{Number: json.Number(e.number.String()), Count: e.count, IsPrime: e.isPrime}
+	if !e.firstSeen.IsZero() {
+		firstSeen, lastSeen := e.firstSeen, e.lastSeen
+		request.FirstSeen, request.LastSeen = &firstSeen, &lastSeen
+	}
+	return request
+}
+
+// checkPrimality is a variable so tests can tell when a check is running.
+var checkPrimality = func(number *big.Int) bool {
+	return primes.Check(number).IsPrime
+}
+
+func isPrime(number *big.Int) bool {
+	start := time.Now()
+	defer func() { primalityCheckDuration.Observe(time.Since(start).Seconds()) }()
+	return checkPrimality(number)
+}
+
+func hourOf(t time.Time) int64 {
+	return t.Unix() / 3600
+}
+
+// update counts a request for the number made at the time, also for the client unless it is empty.
+// It only calls isPrime for a number it doesn't remember yet.
+func (memories *Memories) update(number *big.Int, client string, at time.Time, isPrime func() bool) *Memory {
 	m := *memories
-	var count int
-	var isPrime bool
-	if m[number] == nil {
-		count = 1
-		isPrime = primes.IsPrime(number)
-	} else {
-		count = m[number].Count + 1
-		isPrime = m[number].IsPrime
+	key := number.String()
+	memory := m[key]
+	if memory == nil {
+		memory = &Memory{IsPrime: isPrime()}
+		m[key] = memory
 	}
-	m[number] = &Memory{Count: count, IsPrime: isPrime}
+	hour := hourOf(at)
+	memory.Count++
+	memory.seen(at)
+	if memory.Hours == nil {
+		memory.Hours = make(map[int64]int)
+	}
+	memory.Hours[hour]++
+	if client != "" {
+		if memory.Clients == nil {
+			memory.Clients = make(map[string]int)
+		}
+		memory.Clients[client]++
+		if memory.ClientHours == nil {
+			memory.ClientHours = make(map[string]map[int64]int)
+		}
+		if memory.ClientHours[client] == nil {
+			memory.ClientHours[client] = make(map[int64]int)
+		}
+		memory.ClientHours[client][hour]++
+	}
+	return memory
 }
 
-func (memories Memories) ToPrimeResponse(number int, messages *messages.Service) responses.Primes {
-	return memories[number].ToPrimeResponse(messages)
+// entries are the memories the filter includes, in the order it asks for.
+func (memories Memories) entries(filter Filter) []entry {
+	var entries []entry
+	for key, memory := range memories {
+		count := memory.count(filter.Counting)
+		number, ok := new(big.Int).SetString(key, 10)
+		if ok && count > 0 && filter.includes(number) {
+			entries = append(entries, entry{number: number, count: count, isPrime: memory.IsPrime, firstSeen: memory.FirstSeen, lastSeen: memory.LastSeen})
+		}
+	}
+	filter.sort(entries)
+	return entries
 }
 
-func (memories Memories) ToHistoryResponse() responses.History {
-	var requests []responses.Request
-	for number, memory := range memories {
-		requests = append(requests, responses.Request{Number: number, Count: memory.Count})
+func (memories Memories) ToHistoryResponse(filter Filter) responses.History {
+	requests := []responses.Request{}
+	var totals responses.Totals
+	for _, entry := range memories.entries(filter) {
+		requests = append(requests, entry.toRequest())
+		totals.DistinctNumbers++
+		totals.TotalRequests += entry.count
+		if entry.isPrime {
+			totals.PrimeNumbers++
+			totals.PrimeRequests += entry.count
+		} else {
+			totals.NonPrimeNumbers++
+			totals.NonPrimeRequests += entry.count
+		}
 	}
-	return responses.History{Requests: requests}
+	return responses.History{Requests: requests, Totals: totals}
 }
 
 func (m Memory) update() {
 	m.Count = m.Count + 1
 }
 
-func (m Memory) toMessage(messages *messages.Service) string {
-	if m.IsPrime {
-		return "It is prime. Hurray!"
+func (memories Memories) copy() Memories {
+	copied := make(Memories, len(memories))
+	for key, memory := range memories {
+		copied[key] = memory.copy()
+	}
+	return copied
+}
+
+func (m *Memory) copy() *Memory {
+	copied := *m
+	if m.Clients != nil {
+		copied.Clients = make(map[string]int, len(m.Clients))
+		for client, count := range m.Clients {
+			copied.Clients[client] = count
+		}
+	}
+	copied.Hours = copyHours(m.Hours)
+	if m.ClientHours != nil {
+		copied.ClientHours = make(map[string]map[int64]int, len(m.ClientHours))
+		for client, hours := range m.ClientHours {
+			copied.ClientHours[client] = copyHours(hours)
+		}
 	}
-	return messages.GetMessage(m.Count)
+	return &copied
 }
 
-func (m Memory) ToPrimeResponse(messages *messages.Service) responses.Primes {
-	return responses.Primes{IsPrime: m.IsPrime, Message: m.toMessage(messages)}
+func copyHours(hours map[int64]int) map[int64]int {
+	if hours == nil {
+		return nil
+	}
+	copied := make(map[int64]int, len(hours))
+	for hour, count := range hours {
+		copied[hour] = count
+	}
+	return copied
+}
+func (m *Memory) seen(at time.Time) {
+	if m.FirstSeen.IsZero() || at.Before(m.FirstSeen) {
+		m.FirstSeen = at
+	}
+	if at.After(m.LastSeen) {
+		m.LastSeen = at
+	}
+}
+
+// count returns the number of requests that count. Without a period the totals are exact,
+// within one it counts whole hours that overlap with it.
+func (m Memory) count(counting Counting) int {
+	if counting.Since.IsZero() && counting.Until.IsZero() {
+		if counting.Client == "" {
+			return m.Count
+		}
+		return m.Clients[counting.Client]
+	}
+	hours := m.Hours
+	if counting.Client != "" {
+		hours = m.ClientHours[counting.Client]
+	}
+	total := 0
+	for hour, count := range hours {
+		start := time.Unix(hour*3600, 0)
+		if (counting.Since.IsZero() || start.Add(time.Hour).After(counting.Since)) && (counting.Until.IsZero() || !start.After(counting.Until)) {
+			total += count
+		}
+	}
+	return total
+}
+
+// toPrimeResponse picks the feedback message by the count of the requests that count.
+func toPrimeResponse(ctx context.Context, number *big.Int, prime bool, count int, feedbackMessages *messages.Service) responses.Primes {
+	facts := messages.NewFacts(number, count, prime)
+	primality := primes.Describe(number, prime)
+	response := responses.Primes{
+		IsPrime:   prime,
+		Message:   feedbackMessages.GetMessage(ctx, facts),
+		Verdict:   primality.Verdict(),
+		Rounds:    primality.Rounds,
+		Certainty: primality.Certainty,
+	}
+	if factor := facts.Factor(); factor != nil {
+		response.SmallestFactor = json.Number(factor.String())
+	}
+	return response
 }
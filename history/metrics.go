@@ -0,0 +1,20 @@
+package history
+
+import "tbp.com/user/hello/metrics"
+
+var (
+	primalityCheckDuration = metrics.NewHistogram("hello_primality_check_duration_seconds", "Time it took to check whether a number is prime.", metrics.DefaultBuckets)
+	numberRequests         = metrics.NewCounter("hello_number_requests_total", "Requests for a number, counted in the history.")
+	repeatedNumberRequests = metrics.NewCounter("hello_repeated_number_requests_total", "Requests for a number that was asked for before.")
+	historySize            = metrics.NewGauge("hello_history_numbers", "Distinct numbers in the history.")
+	forgottenNumbers       = metrics.NewCounter("hello_history_forgotten_numbers_total", "Numbers forgotten by the history retention, by whether they expired, were evicted or decayed.", "reason")
+	decayedRequests        = metrics.NewCounter("hello_history_decayed_requests_total", "Requests the counts in the history decayed by.")
+	retentionDuration      = metrics.NewHistogram("hello_history_retention_duration_seconds", "Time it took to apply the history retention.", metrics.DefaultBuckets)
+	_                      = metrics.NewGaugeFunc("hello_repeated_number_requests_ratio", "Share of requests for a number that was asked for before.", func() float64 {
+		total := numberRequests.Value()
+		if total == 0 {
+			return 0
+		}
+		return repeatedNumberRequests.Value() / total
+	})
+)
@@ -0,0 +1,167 @@
+package history
+
+import (
+	"math"
+	"sort"
+	"tbp.com/user/hello/logging"
+	"time"
+)
+
+// Retention keeps the history from growing forever. A zero value turns that part off.
+type Retention struct {
+	// MaxAge forgets numbers that were not asked for within that time, and the hours of the others that are older.
+	MaxAge time.Duration
+	// MaxNumbers forgets the numbers that were asked for least recently once there are more of them.
+	MaxNumbers int
+	// HalfLife halves the counts of every number and client in that time. Numbers that decay to 0 are forgotten.
+	HalfLife time.Duration
+	// Interval is how often the retention is applied in the background.
+	Interval time.Duration
+}
+
+// retained tells what applying a retention did.
+type retained struct {
+	expired   int
+	evicted   int
+	decayed   int
+	forgotten int
+	remaining int
+}
+
+func (r Retention) enabled() bool {
+	return r.Interval > 0 && (r.MaxAge > 0 || r.MaxNumbers > 0 || r.HalfLife > 0)
+}
+
+// Retain applies the retention every interval until the service is closed. It does nothing if it is turned off.
+func (s *Service) Retain(retention Retention) {
+	if !retention.enabled() {
+		return
+	}
+	s.retaining.Add(1)
+	go func() {
+		defer s.retaining.Done()
+		ticker := time.NewTicker(retention.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.applyRetention(retention)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// applyRetention persists the memories right away, so decayed counts can't be decayed again after replaying the journal.
+func (s *Service) applyRetention(retention Retention) retained {
+	start := time.Now()
+	s.writeMutex.Lock()
+	s.mutex.Lock()
+	result := s.memories.retain(retention, s.now())
+	historySize.Set(float64(len(s.memories)))
+	// Retention may change every memory
+	s.persistAll = true
+	snapshot, err := s.snapshot()
+	s.mutex.Unlock()
+	err = s.write(snapshot, err)
+	s.writeMutex.Unlock()
+
+	forgottenNumbers.Add(float64(result.expired), "expired")
+	forgottenNumbers.Add(float64(result.evicted), "evicted")
+	forgottenNumbers.Add(float64(result.forgotten), "decayed")
+	decayedRequests.Add(float64(result.decayed))
+	retentionDuration.Observe(time.Since(start).Seconds())
+	logging.Info("Applied history retention", "component", "history",
+		"expired", result.expired, "evicted", result.evicted, "decayedRequests", result.decayed, "decayedNumbers", result.forgotten,
+		"remaining", result.remaining, "durationSeconds", time.Since(start).Seconds())
+	if err != nil {
+		logging.Error("Failed to persist history", "component", "history", "error", err)
+	}
+	return result
+}
+
+func (memories Memories) retain(retention Retention, now time.Time) retained {
+	var result retained
+	if retention.MaxAge > 0 {
+		oldest := now.Add(-retention.MaxAge)
+		for key, memory := range memories {
+			// Memories from before requests were timestamped can't expire
+			if !memory.LastSeen.IsZero() && memory.LastSeen.Before(oldest) {
+				delete(memories, key)
+				result.expired++
+				continue
+			}
+			memory.forgetHoursBefore(hourOf(oldest))
+		}
+	}
+	if retention.HalfLife > 0 {
+		for key, memory := range memories {
+			result.decayed += memory.decay(retention.HalfLife, now)
+			if memory.Count == 0 {
+				delete(memories, key)
+				result.forgotten++
+			}
+		}
+	}
+	if retention.MaxNumbers > 0 && len(memories) > retention.MaxNumbers {
+		keys := make([]string, 0, len(memories))
+		for key := range memories {
+			keys = append(keys, key)
+		}
+		// Memories from before requests were timestamped were never seen, so they go first
+		sort.Slice(keys, func(i, j int) bool {
+			return memories[keys[i]].LastSeen.Before(memories[keys[j]].LastSeen)
+		})
+		for _, key := range keys[:len(keys)-retention.MaxNumbers] {
+			delete(memories, key)
+			result.evicted++
+		}
+	}
+	result.remaining = len(memories)
+	return result
+}
+
+func (m *Memory) forgetHoursBefore(oldest int64) {
+	for hour := range m.Hours {
+		if hour < oldest {
+			delete(m.Hours, hour)
+		}
+	}
+	for client, hours := range m.ClientHours {
+		for hour := range hours {
+			if hour < oldest {
+				delete(hours, hour)
+			}
+		}
+		if len(hours) == 0 {
+			delete(m.ClientHours, client)
+		}
+	}
+}
+
+// decay scales the counts down by the time that passed since they last decayed, and returns by how many requests.
+// Counts are whole numbers, so Decayed only moves on once the total count goes down,
+// otherwise frequent retention would round the decay away. Hours are not decayed, they expire with MaxAge.
+func (m *Memory) decay(halfLife time.Duration, now time.Time) int {
+	if m.Decayed.IsZero() {
+		m.Decayed = now
+		return 0
+	}
+	factor := math.Pow(0.5, float64(now.Sub(m.Decayed))/float64(halfLife))
+	count := int(math.Round(float64(m.Count) * factor))
+	if count == m.Count {
+		return 0
+	}
+	decayed := m.Count - count
+	m.Count = count
+	for client, clientCount := range m.Clients {
+		if clientCount = int(math.Round(float64(clientCount) * factor)); clientCount > 0 {
+			m.Clients[client] = clientCount
+		} else {
+			delete(m.Clients, client)
+		}
+	}
+	m.Decayed = now
+	return decayed
+}
@@ -1,50 +1,354 @@
 package history
 
 import (
-	"log"
+	"context"
+	"math/big"
+	"sync"
+	"tbp.com/user/hello/logging"
 	"tbp.com/user/hello/messages"
 	"tbp.com/user/hello/repository"
 	"tbp.com/user/hello/responses"
+	"time"
 )
 
+var (
+	// persistDelay is how long it has to be quiet after a change before the memories are persisted as a whole.
+	persistDelay = time.Second
+	// maxPersistDelay makes sure the memories are persisted even when changes keep coming in.
+	maxPersistDelay = 10 * time.Second
+)
+
+// Every update is written to the journal before it is acknowledged.
+// The journal holds the resulting count rather than the increment itself,
+// so replaying it on top of a snapshot that already contains some of its changes does no harm.
+// A single goroutine persists the memories as a whole once changes settle down. It copies them and starts a new
+// journal segment while holding the lock, writes the copy after releasing it, and then drops the older segments.
 type Service struct {
-	repository repository.FileRepository
-	memories   Memories
+	now             func() time.Time
+	mutex           sync.RWMutex
+	repository      repository.Repository
+	journal         repository.Journal
+	memories        Memories
+	changed         chan struct{}
+	persistDelay    time.Duration
+	maxPersistDelay time.Duration
+	stop            chan struct{}
+	stopped         chan struct{}
+	closeOnce       sync.Once
+	retaining       sync.WaitGroup
+	// changedKeys holds the keys of the memories that changed since they were last copied to be persisted.
+	// A repository that stores them on their own only gets those, unless all of them have to be persisted.
+	changedKeys map[string]bool
+	persistAll  bool
+
+	// writeMutex makes sure copies of the memories are persisted in the order they were taken
+	writeMutex   sync.Mutex
+	persistMutex sync.Mutex
+	persistError error
 }
 
-func Setup(folderName string) (Service, error) {
-	repository, err := repository.Initialize(folderName, "history")
+// A change holds the counts after a request, including those of the hour it was made in.
+type change struct {
+	Number          string    `json:"number"`
+	Count           int       `json:"count"`
+	IsPrime         bool      `json:"isPrime"`
+	Client          string    `json:"client,omitempty"`
+	ClientCount     int       `json:"clientCount,omitempty"`
+	Time            time.Time `json:"time"`
+	HourCount       int       `json:"hourCount"`
+	ClientHourCount int       `json:"clientHourCount,omitempty"`
+}
+
+func Setup(repository repository.Repository, journal repository.Journal) (*Service, error) {
 	var memories Memories
-	err = repository.ReadAll(&memories)
-	if memories == nil && err == nil {
-		memories = make(map[int]*Memory)
-		err = repository.Persist(memories)
+	err := repository.ReadAll(&memories)
+	if err != nil {
+		return nil, err
+	}
+	if memories == nil {
+		memories = make(map[string]*Memory)
 	}
-	service := Service{memories: memories, repository: repository}
-	return service, err
+	err = journal.Replay(func(decode func(interface{}) error) error {
+		var c change
+		if err := decode(&c); err != nil {
+			return err
+		}
+		memory := memories[c.Number]
+		if memory == nil {
+			memory = &Memory{}
+			memories[c.Number] = memory
+		}
+		memory.apply(c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := compact(repository, journal, memories); err != nil {
+		return nil, err
+	}
+	s := SetupWith(memories, repository, journal)
+	s.persistAll = false
+	return s, nil
 }
 
-func SetupWith(memories Memories, folderName string) (Service, error) {
-	repository, err := repository.Initialize(folderName, "history")
-	return Service{memories: memories, repository: repository}, err
+func SetupWith(memories Memories, repository repository.Repository, journal repository.Journal) *Service {
+	s := &Service{
+		now:             time.Now,
+		memories:        memories,
+		repository:      repository,
+		journal:         journal,
+		changed:         make(chan struct{}, 1),
+		persistDelay:    persistDelay,
+		maxPersistDelay: maxPersistDelay,
+		stop:            make(chan struct{}),
+		stopped:         make(chan struct{}),
+		changedKeys:     make(map[string]bool),
+		persistAll:      true,
+	}
+	historySize.Set(float64(len(memories)))
+	go s.persistLoop()
+	return s
 }
 
-func (s Service) ToHistoryResponse() responses.History {
-	return s.memories.ToHistoryResponse()
+func (s *Service) ToHistoryResponse(filter Filter) responses.History {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.memories.ToHistoryResponse(filter)
+}
+
+// EachRequest hands the requests ToHistoryResponse would hold to send one at a time, until it returns false.
+// The lock is released before the first one is sent, so a slow client doesn't hold up updates.
+func (s *Service) EachRequest(filter Filter, send func(responses.Request) bool) {
+	s.mutex.RLock()
+	entries := s.memories.entries(filter)
+	s.mutex.RUnlock()
+	for _, entry := range entries {
+		if !send(entry.toRequest()) {
+			return
+		}
+	}
+}
+
+// Update counts a request for the number, by the client unless it is empty.
+// Failing to journal it is logged with the logger of the context.
+func (s *Service) Update(ctx context.Context, number *big.Int, client string) {
+	key := number.String()
+	// Checking a new number can take a while, so that is done before taking the lock.
+	// Another request for it may have checked it meanwhile, that's fine.
+	s.mutex.RLock()
+	known := s.memories[key] != nil
+	s.mutex.RUnlock()
+	checked := func() bool { return isPrime(number) }
+	if !known {
+		result := isPrime(number)
+		checked = func() bool { return result }
+	}
+	s.mutex.Lock()
+	at := s.now()
+	memory := s.memories.update(number, client, at, checked)
+	s.changedKeys[key] = true
+	count := memory.Count
+	historySize.Set(float64(len(s.memories)))
+	// Appending while holding the lock keeps the journal in the same order as the updates
+	err := s.journal.Append(change{
+		Number:          key,
+		Count:           count,
+		IsPrime:         memory.IsPrime,
+		Client:          client,
+		ClientCount:     memory.Clients[client],
+		Time:            at,
+		HourCount:       memory.Hours[hourOf(at)],
+		ClientHourCount: memory.ClientHours[client][hourOf(at)],
+	})
+	s.mutex.Unlock()
+	if err == nil {
+		err = s.journal.Sync()
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to journal request", "component", "history", "number", key, "error", err)
+	}
+	numberRequests.Inc()
+	if count > 1 {
+		repeatedNumberRequests.Inc()
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// ToPrimeResponse answers for a number after Update counted it.
+// Retention or a Reset may forget the number in between, then it is answered like Peek answers for a number it doesn't know.
+func (s *Service) ToPrimeResponse(ctx context.Context, number *big.Int, counting Counting, feedbackMessages *messages.Service) responses.Primes {
+	return s.Peek(ctx, number, counting, feedbackMessages)
+}
+
+// Peek answers like ToPrimeResponse, without counting a request. A number that was never asked for is checked, but not remembered.
+// Only the count is taken while holding the lock, executing the message template and looking for a factor can take a while.
+func (s *Service) Peek(ctx context.Context, number *big.Int, counting Counting, feedbackMessages *messages.Service) responses.Primes {
+	s.mutex.RLock()
+	memory := s.memories[number.String()]
+	if memory == nil {
+		s.mutex.RUnlock()
+		return toPrimeResponse(ctx, number, isPrime(number), 0, feedbackMessages)
+	}
+	prime, count := memory.IsPrime, memory.count(counting)
+	s.mutex.RUnlock()
+	return toPrimeResponse(ctx, number, prime, count, feedbackMessages)
 }
 
-func (s Service) Update(number int) {
-	s.memories.Update(number)
-	go s.persist()
+// Reset forgets all memories, both in memory and in the repository.
+func (s *Service) Reset() error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.mutex.Lock()
+	s.memories = make(Memories)
+	historySize.Set(0)
+	s.persistAll = true
+	snapshot, err := s.snapshot()
+	s.mutex.Unlock()
+	return s.write(snapshot, err)
 }
 
-func (s Service) ToPrimeResponse(number int, feedbackMessages *messages.Service) responses.Primes {
-	return s.memories.ToPrimeResponse(number, feedbackMessages)
+// LastPersistError returns why the memories could not be persisted the last time, or nil if that succeeded.
+func (s *Service) LastPersistError() error {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	return s.persistError
 }
 
-func (s Service) persist() {
-	err := s.repository.Persist(s.memories)
+func (s *Service) remember(err error) error {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	s.persistError = err
+	return err
+}
+
+// Close stops persisting and applying the retention in the background and persists the memories one last time.
+func (s *Service) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.stopped
+	s.retaining.Wait()
+	return s.persist()
+}
+
+func (s *Service) persistLoop() {
+	defer close(s.stopped)
+	for {
+		select {
+		case <-s.changed:
+		case <-s.stop:
+			return
+		}
+		deadline := time.After(s.maxPersistDelay)
+	settling:
+		for {
+			select {
+			case <-s.changed:
+			case <-time.After(s.persistDelay):
+				break settling
+			case <-deadline:
+				break settling
+			case <-s.stop:
+				return
+			}
+		}
+		if err := s.persist(); err != nil {
+			logging.Error("Failed to persist history", "component", "history", "error", err)
+		}
+	}
+}
+
+// persist only holds the lock while copying the memories, updates don't wait for them to be written.
+func (s *Service) persist() error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.mutex.Lock()
+	snapshot, err := s.snapshot()
+	s.mutex.Unlock()
+	return s.write(snapshot, err)
+}
+
+// A snapshot is a copy of the memories to persist, either all of them or only those that changed.
+type snapshot struct {
+	memories Memories
+	all      bool
+}
+
+// snapshot copies the memories and appends the changes after it to a new journal segment. It needs the write lock.
+func (s *Service) snapshot() (snapshot, error) {
+	_, entries := s.repository.(repository.EntryRepository)
+	taken := snapshot{all: s.persistAll || !entries}
+	if taken.all {
+		taken.memories = s.memories.copy()
+	} else {
+		taken.memories = make(Memories, len(s.changedKeys))
+		for key := range s.changedKeys {
+			// Only retention and Reset forget memories, and they persist all of them
+			if memory := s.memories[key]; memory != nil {
+				taken.memories[key] = memory.copy()
+			}
+		}
+	}
+	s.changedKeys = make(map[string]bool)
+	s.persistAll = false
+	return taken, s.journal.StartSegment()
+}
+
+// write persists a snapshot without holding the lock, and then drops the journal segments it contains.
+// When that fails, all memories are persisted the next time.
+func (s *Service) write(snapshot snapshot, err error) error {
+	if err == nil && snapshot.all {
+		err = s.repository.Persist(snapshot.memories)
+	} else if err == nil {
+		err = s.repository.(repository.EntryRepository).PersistEntries(snapshot.memories)
+	}
+	if err == nil {
+		err = s.journal.DropOlderSegments()
+	}
+	if err != nil {
+		s.mutex.Lock()
+		s.persistAll = true
+		s.mutex.Unlock()
+	}
+	return s.remember(err)
+}
+
+func compact(repository repository.Repository, journal repository.Journal, memories Memories) error {
+	err := repository.Persist(memories)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	return journal.Truncate()
+}
+
+// apply sets the counts of a change. Journals from before requests were timestamped have no time.
+func (m *Memory) apply(c change) {
+	m.Count, m.IsPrime = c.Count, c.IsPrime
+	if c.Client != "" {
+		if m.Clients == nil {
+			m.Clients = make(map[string]int)
+		}
+		m.Clients[c.Client] = c.ClientCount
+	}
+	if c.Time.IsZero() {
+		return
+	}
+	m.seen(c.Time)
+	if m.Hours == nil {
+		m.Hours = make(map[int64]int)
+	}
+	m.Hours[hourOf(c.Time)] = c.HourCount
+	if c.Client != "" {
+		if m.ClientHours == nil {
+			m.ClientHours = make(map[string]map[int64]int)
+		}
+		if m.ClientHours[c.Client] == nil {
+			m.ClientHours[c.Client] = make(map[int64]int)
+		}
+		m.ClientHours[c.Client][hourOf(c.Time)] = c.ClientHourCount
 	}
 }
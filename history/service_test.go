@@ -0,0 +1,470 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"tbp.com/user/hello/messages"
+	"tbp.com/user/hello/repository"
+	"tbp.com/user/hello/responses"
+	"testing"
+	"time"
+)
+
+func TestSetupReplaysJournal(t *testing.T) {
+	folder := t.TempDir()
+	fileRepository, err := repository.Initialize(folder, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := repository.InitializeFileJournal(folder, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := Setup(fileRepository, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []int64{4, 4, 7} {
+		service.Update(context.Background(), big.NewInt(number), "")
+	}
+	// Simulates a crash after persisting a snapshot, but before the journal was truncated
+	close(service.stop)
+	<-service.stopped
+	if err := fileRepository.Persist(service.memories); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	journal, err = repository.InitializeFileJournal(folder, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	restarted, err := Setup(fileRepository, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	if len(restarted.memories) != 2 || restarted.memories["4"].Count != 2 || restarted.memories["7"].Count != 1 {
+		t.Errorf("Expected counts to survive a restart, but got 4: %+v and 7: %+v", restarted.memories["4"], restarted.memories["7"])
+	}
+	if !restarted.memories["7"].IsPrime {
+		t.Error("Expected 7 to be remembered as prime")
+	}
+}
+
+func TestConcurrentUpdatesAreCountedAndPersisted(t *testing.T) {
+	defer func(delay time.Duration) { persistDelay = delay }(persistDelay)
+	persistDelay = time.Millisecond
+
+	memoryRepository := repository.InitializeMemory()
+	service, err := Setup(memoryRepository, repository.InitializeMemoryJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedbackMessages, err := messages.Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const goroutines, updates = 8, 250
+	var wait sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wait.Add(1)
+		go func(g int) {
+			defer wait.Done()
+			for u := 0; u < updates; u++ {
+				number := big.NewInt(int64(u % 10))
+				client := fmt.Sprintf("ip:192.0.2.%d", g%2)
+				service.Update(context.Background(), number, client)
+				service.ToPrimeResponse(context.Background(), number, Counting{Client: client}, feedbackMessages)
+				if u%50 == 0 {
+					service.ToHistoryResponse(Filter{SortBy: SortByCount})
+				}
+			}
+		}(g)
+	}
+	wait.Wait()
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	history := service.ToHistoryResponse(Filter{SortBy: SortByNumber})
+	if history.Totals.TotalRequests != goroutines*updates {
+		t.Errorf("Expected %d requests, but got %d", goroutines*updates, history.Totals.TotalRequests)
+	}
+	if clientHistory := service.ToHistoryResponse(Filter{SortBy: SortByNumber, Counting: Counting{Client: "ip:192.0.2.1"}}); clientHistory.Totals.TotalRequests != goroutines/2*updates {
+		t.Errorf("Expected %d requests by one client, but got %d", goroutines/2*updates, clientHistory.Totals.TotalRequests)
+	}
+	var persisted Memories
+	if err := memoryRepository.ReadAll(&persisted); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, memory := range persisted {
+		total += memory.Count
+	}
+	if total != goroutines*updates {
+		t.Errorf("Expected %d requests to be persisted, but got %d", goroutines*updates, total)
+	}
+}
+
+func TestClientCountsAreReplayed(t *testing.T) {
+	folder := t.TempDir()
+	fileRepository, err := repository.Initialize(folder, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := repository.InitializeFileJournal(folder, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := Setup(fileRepository, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []string{"client:dashboard", "ip:192.0.2.1", "client:dashboard", ""} {
+		service.Update(context.Background(), big.NewInt(9), client)
+	}
+	// Simulates a crash before anything was persisted
+	close(service.stop)
+	<-service.stopped
+	journal.Close()
+
+	journal, err = repository.InitializeFileJournal(folder, "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	restarted, err := Setup(fileRepository, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	memory := restarted.memories["9"]
+	if memory.Count != 4 || memory.Clients["client:dashboard"] != 2 || memory.Clients["ip:192.0.2.1"] != 1 || len(memory.Clients) != 2 {
+		t.Errorf("Expected 4 requests, 2 by the dashboard and 1 by an IP address, but got %+v", memory)
+	}
+	requests := restarted.ToHistoryResponse(Filter{SortBy: SortByNumber, Counting: Counting{Client: "client:dashboard"}}).Requests
+	if len(requests) != 1 || requests[0].Count != 2 {
+		t.Errorf("Expected 2 requests for 9 by the dashboard, but got %+v", requests)
+	}
+	if requests := restarted.ToHistoryResponse(Filter{SortBy: SortByNumber, Counting: Counting{Client: "ip:192.0.2.2"}}).Requests; len(requests) != 0 {
+		t.Errorf("Expected no requests by another client, but got %+v", requests)
+	}
+}
+
+func TestCountsWithinAPeriod(t *testing.T) {
+	service, err := Setup(repository.InitializeMemory(), repository.InitializeMemoryJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	feedbackMessages, err := messages.Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)
+	for _, after := range []time.Duration{0, 2 * time.Hour, 25 * time.Hour} {
+		service.now = func() time.Time { return start.Add(after) }
+		service.Update(context.Background(), big.NewInt(9), "ip:192.0.2.1")
+	}
+	last := start.Add(25 * time.Hour)
+
+	testCases := []struct {
+		name     string
+		counting Counting
+		expected int
+	}{
+		{"Counts all requests without a period", Counting{}, 3},
+		{"Counts whole hours since", Counting{Since: start.Add(2*time.Hour + 15*time.Minute)}, 2},
+		{"Counts whole hours until", Counting{Until: start.Add(time.Hour)}, 1},
+		{"Counts requests of a client", Counting{Client: "ip:192.0.2.1", Since: start.Add(time.Hour), Until: last}, 2},
+		{"Counts nothing outside the period", Counting{Since: last.Add(time.Hour)}, 0},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			history := service.ToHistoryResponse(Filter{SortBy: SortByNumber, Counting: testCase.counting})
+			if history.Totals.TotalRequests != testCase.expected {
+				t.Errorf("Expected %d requests, but got %+v", testCase.expected, history)
+			}
+		})
+	}
+
+	requests := service.ToHistoryResponse(Filter{SortBy: SortByNumber}).Requests
+	if len(requests) != 1 || !requests[0].FirstSeen.Equal(start) || !requests[0].LastSeen.Equal(last) {
+		t.Errorf("Expected 9 to be seen first at %s and last at %s, but got %+v", start, last, requests)
+	}
+	if message := service.ToPrimeResponse(context.Background(), big.NewInt(9), Counting{}, feedbackMessages).Message; message != "No, and we already told you so!" {
+		t.Errorf("Expected to be told again after 3 requests, but got %q", message)
+	}
+	if message := service.ToPrimeResponse(context.Background(), big.NewInt(9), Counting{Since: last.Add(-24 * time.Hour)}, feedbackMessages).Message; message != "No" {
+		t.Errorf("Expected only 2 requests within a day, but got %q", message)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	setup := func(t *testing.T) (*Service, *repository.MemoryRepository) {
+		memoryRepository := repository.InitializeMemory()
+		service, err := Setup(memoryRepository, repository.InitializeMemoryJournal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { service.Close() })
+		// 4 is asked for 8 times on the first day, 6 once a day later and 9 once on each of the first 3 days
+		for _, request := range []struct {
+			number int64
+			after  time.Duration
+		}{{4, 0}, {4, 0}, {4, 0}, {4, 0}, {4, 0}, {4, 0}, {4, 0}, {4, 0}, {9, 0}, {6, 24 * time.Hour}, {9, 24 * time.Hour}, {9, 48 * time.Hour}} {
+			service.now = func() time.Time { return start.Add(request.after) }
+			service.Update(context.Background(), big.NewInt(request.number), "ip:192.0.2.1")
+		}
+		return service, memoryRepository
+	}
+
+	t.Run("Forgets numbers and hours older than the maximum age", func(t *testing.T) {
+		service, memoryRepository := setup(t)
+		service.now = func() time.Time { return start.Add(72 * time.Hour) }
+		result := service.applyRetention(Retention{MaxAge: 36 * time.Hour})
+		if result.expired != 2 || result.remaining != 1 {
+			t.Errorf("Expected 4 and 6 to expire, but got %+v", result)
+		}
+		if count := service.ToHistoryResponse(Filter{Counting: Counting{Since: start}}).Totals.TotalRequests; count != 1 {
+			t.Errorf("Expected only the last hour of 9 to be left, but counted %d requests", count)
+		}
+		var persisted Memories
+		if err := memoryRepository.ReadAll(&persisted); err != nil {
+			t.Fatal(err)
+		}
+		if len(persisted) != 1 || persisted["9"] == nil {
+			t.Errorf("Expected the retention to be persisted, but got %+v", persisted)
+		}
+	})
+
+	t.Run("Evicts the numbers asked for least recently", func(t *testing.T) {
+		service, _ := setup(t)
+		result := service.applyRetention(Retention{MaxNumbers: 2})
+		if result.evicted != 1 || service.memories["4"] != nil {
+			t.Errorf("Expected 4 to be evicted, but got %+v", result)
+		}
+	})
+
+	t.Run("Halves counts every half-life", func(t *testing.T) {
+		service, _ := setup(t)
+		service.now = func() time.Time { return start.Add(48 * time.Hour) }
+		service.applyRetention(Retention{HalfLife: 24 * time.Hour})
+		service.now = func() time.Time { return start.Add(96 * time.Hour) }
+		result := service.applyRetention(Retention{HalfLife: 24 * time.Hour})
+		if result.decayed != 9 || result.forgotten != 1 {
+			t.Errorf("Expected 4 to decay to 2, 9 to 1 and 6 to be forgotten, but got %+v", result)
+		}
+		if memory := service.memories["4"]; memory.Count != 2 || memory.Clients["ip:192.0.2.1"] != 2 {
+			t.Errorf("Expected the count of 4 and its client to decay to 2, but got %+v", memory)
+		}
+		if service.memories["9"].Count != 1 || service.memories["6"] != nil {
+			t.Errorf("Expected 9 to decay to 1 and 6 to be forgotten, but got 9: %+v and 6: %+v", service.memories["9"], service.memories["6"])
+		}
+	})
+}
+
+func TestRetainStopsOnClose(t *testing.T) {
+	service, err := Setup(repository.InitializeMemory(), repository.InitializeMemoryJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.Retain(Retention{MaxNumbers: 1, Interval: time.Millisecond})
+	for number := int64(1); number <= 3; number++ {
+		service.Update(context.Background(), big.NewInt(number), "")
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(service.ToHistoryResponse(Filter{}).Requests) > 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if requests := service.ToHistoryResponse(Filter{}).Requests; len(requests) != 1 {
+		t.Errorf("Expected all but 1 number to be evicted in the background, but got %+v", requests)
+	}
+}
+
+func TestAnswersForANumberForgottenAfterItWasCounted(t *testing.T) {
+	service, err := Setup(repository.InitializeMemory(), repository.InitializeMemoryJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	feedbackMessages, err := messages.Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.Update(context.Background(), big.NewInt(9), "ip:192.0.2.1")
+	if err := service.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	response := service.ToPrimeResponse(context.Background(), big.NewInt(9), Counting{Client: "ip:192.0.2.1"}, feedbackMessages)
+	if response.IsPrime || response.Message != "No" || response.SmallestFactor != "3" {
+		t.Errorf("Expected 9 to be answered as not prime after it was forgotten, but got %+v", response)
+	}
+}
+
+func TestEachRequestStopsWhenToldTo(t *testing.T) {
+	service, err := Setup(repository.InitializeMemory(), repository.InitializeMemoryJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	for _, number := range []int64{9, 4, 7, 4} {
+		service.Update(context.Background(), big.NewInt(number), "")
+	}
+	var sent []string
+	service.EachRequest(Filter{SortBy: SortByCount, Descending: true}, func(request responses.Request) bool {
+		sent = append(sent, fmt.Sprintf("%s:%d", request.Number, request.Count))
+		return len(sent) < 2
+	})
+	if fmt.Sprint(sent) != "[4:2 9:1]" {
+		t.Errorf("Expected 4 and then 9 by count, but got %v", sent)
+	}
+}
+
+// slowRepository only persists once it is told to.
+type slowRepository struct {
+	*repository.MemoryRepository
+	persisting chan struct{}
+	proceed    chan struct{}
+}
+
+func (r slowRepository) Persist(data interface{}) error {
+	r.persisting <- struct{}{}
+	<-r.proceed
+	return r.MemoryRepository.Persist(data)
+}
+
+func TestUpdatesDoNotWaitForCheckingNewNumbers(t *testing.T) {
+	defer func(check func(*big.Int) bool) { checkPrimality = check }(checkPrimality)
+	checking, proceed := make(chan struct{}, 1), make(chan struct{})
+	checkPrimality = func(number *big.Int) bool {
+		if number.Int64() == 7 {
+			checking <- struct{}{}
+			<-proceed
+		}
+		return number.ProbablyPrime(20)
+	}
+	service, err := Setup(repository.InitializeMemory(), repository.InitializeMemoryJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	service.Update(context.Background(), big.NewInt(4), "")
+	checked := make(chan struct{}, 1)
+	go func() {
+		service.Update(context.Background(), big.NewInt(7), "")
+		checked <- struct{}{}
+	}()
+	<-checking
+
+	updated := make(chan struct{}, 1)
+	go func() {
+		service.Update(context.Background(), big.NewInt(4), "")
+		updated <- struct{}{}
+	}()
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected to update 4 while 7 is being checked")
+	}
+	proceed <- struct{}{}
+	<-checked
+	if history := service.ToHistoryResponse(Filter{SortBy: SortByNumber}); history.Totals.TotalRequests != 3 {
+		t.Errorf("Expected 3 requests, but got %+v", history.Totals)
+	}
+}
+
+func TestUpdatesDoNotWaitForPersisting(t *testing.T) {
+	defer func(delay, maxDelay time.Duration) { persistDelay, maxPersistDelay = delay, maxDelay }(persistDelay, maxPersistDelay)
+	persistDelay, maxPersistDelay = time.Hour, time.Hour
+	journal := repository.InitializeMemoryJournal()
+	slow := slowRepository{MemoryRepository: repository.InitializeMemory(), persisting: make(chan struct{}), proceed: make(chan struct{})}
+	service := SetupWith(make(Memories), slow, journal)
+	service.Update(context.Background(), big.NewInt(4), "")
+	persisted := make(chan error, 1)
+	go func() {
+		persisted <- service.persist()
+	}()
+	<-slow.persisting
+
+	updated := make(chan struct{}, 1)
+	go func() {
+		service.Update(context.Background(), big.NewInt(6), "")
+		updated <- struct{}{}
+	}()
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected to update while the memories are being persisted")
+	}
+	slow.proceed <- struct{}{}
+	if err := <-persisted; err != nil {
+		t.Fatal(err)
+	}
+
+	var stored Memories
+	if err := slow.ReadAll(&stored); err != nil {
+		t.Fatal(err)
+	}
+	replayed := 0
+	err := journal.Replay(func(decode func(interface{}) error) error {
+		replayed++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored["4"] == nil || replayed != 1 {
+		t.Errorf("Expected 4 to be persisted and 6 to be left in the journal, but got %d memories and %d changes", len(stored), replayed)
+	}
+	// Close persists once more
+	go func() { <-slow.persisting; slow.proceed <- struct{}{} }()
+	service.Close()
+}
+
+// entryRepository remembers which entries it was asked to persist on their own.
+type entryRepository struct {
+	*repository.MemoryRepository
+	persisted []string
+}
+
+func (r *entryRepository) PersistEntries(entries interface{}) error {
+	var keys []string
+	for key := range entries.(Memories) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	r.persisted = append(r.persisted, fmt.Sprint(keys))
+	return nil
+}
+
+func TestPersistsOnlyChangedMemories(t *testing.T) {
+	defer func(delay, maxDelay time.Duration) { persistDelay, maxPersistDelay = delay, maxDelay }(persistDelay, maxPersistDelay)
+	persistDelay, maxPersistDelay = time.Hour, time.Hour
+	entries := &entryRepository{MemoryRepository: repository.InitializeMemory()}
+	service, err := Setup(entries, repository.InitializeMemoryJournal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	for _, numbers := range [][]int64{{4, 6}, {6}} {
+		for _, number := range numbers {
+			service.Update(context.Background(), big.NewInt(number), "")
+		}
+		if err := service.persist(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(entries.persisted) != "[[4 6] [6]]" {
+		t.Errorf("Expected 4 and 6 and then only 6 to be persisted, but got %v", entries.persisted)
+	}
+}
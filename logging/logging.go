@@ -0,0 +1,200 @@
+// Package logging writes structured log lines as JSON objects, one per line, in the spirit of log/slog.
+// Every line has the time, level and message, followed by the key/value pairs it was given, in that order.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LevelInfo  = "INFO"
+	LevelWarn  = "WARN"
+	LevelError = "ERROR"
+)
+
+// RequestIDHeader is the header a request ID is taken from, and returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength keeps clients from filling the logs through the request ID they send.
+const maxRequestIDLength = 128
+
+// Logger writes lines with its own key/value pairs in front of the ones given to every call.
+// Loggers derived from one another with With share the writer, so lines never get mixed up.
+type Logger struct {
+	output *output
+	attrs  []interface{}
+}
+
+type output struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+var defaultLogger = New(os.Stderr)
+
+func New(writer io.Writer) *Logger {
+	return &Logger{output: &output{writer: writer}}
+}
+
+// Default is the logger of the server, that the package level functions write to.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger. The standard log package writes its lines to it as well.
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+	log.SetFlags(0)
+	log.SetOutput(logger.writer(LevelInfo))
+}
+
+// With returns a logger that adds the key/value pairs to every line.
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	attrs := append(append([]interface{}{}, l.attrs...), keyValues...)
+	return &Logger{output: l.output, attrs: attrs}
+}
+
+func (l *Logger) Info(message string, keyValues ...interface{}) {
+	l.write(LevelInfo, message, keyValues)
+}
+
+func (l *Logger) Warn(message string, keyValues ...interface{}) {
+	l.write(LevelWarn, message, keyValues)
+}
+
+func (l *Logger) Error(message string, keyValues ...interface{}) {
+	l.write(LevelError, message, keyValues)
+}
+
+// StandardLogger returns a log.Logger for code that only knows the standard log package, like http.Server.
+// Every line it gets is written with the level.
+func (l *Logger) StandardLogger(level string) *log.Logger {
+	return log.New(l.writer(level), "", 0)
+}
+
+func (l *Logger) writer(level string) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		l.write(level, strings.TrimSuffix(string(p), "\n"), nil)
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (l *Logger) write(level string, message string, keyValues []interface{}) {
+	var line bytes.Buffer
+	line.WriteString(`{"time":`)
+	writeValue(&line, time.Now().UTC().Format(time.RFC3339Nano))
+	line.WriteString(`,"level":`)
+	writeValue(&line, level)
+	line.WriteString(`,"msg":`)
+	writeValue(&line, message)
+	writePairs(&line, l.attrs)
+	writePairs(&line, keyValues)
+	line.WriteString("}\n")
+	l.output.mutex.Lock()
+	defer l.output.mutex.Unlock()
+	l.output.writer.Write(line.Bytes())
+}
+
+// writePairs writes the keys and values after one another. A value without key gets the key "!BADKEY", like log/slog does.
+func writePairs(line *bytes.Buffer, keyValues []interface{}) {
+	for i := 0; i < len(keyValues); i += 2 {
+		key, ok := keyValues[i].(string)
+		value := interface{}(nil)
+		switch {
+		case !ok:
+			key, value = "!BADKEY", keyValues[i]
+			i--
+		case i+1 < len(keyValues):
+			value = keyValues[i+1]
+		}
+		line.WriteByte(',')
+		writeValue(line, key)
+		line.WriteByte(':')
+		writeValue(line, value)
+	}
+}
+
+func writeValue(line *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	line.Write(encoded)
+}
+
+func Info(message string, keyValues ...interface{}) {
+	defaultLogger.Info(message, keyValues...)
+}
+
+func Warn(message string, keyValues ...interface{}) {
+	defaultLogger.Warn(message, keyValues...)
+}
+
+func Error(message string, keyValues ...interface{}) {
+	defaultLogger.Error(message, keyValues...)
+}
+
+// Fatal logs the error and exits.
+func Fatal(message string, err error) {
+	defaultLogger.Error(message, "error", err)
+	os.Exit(1)
+}
+
+type contextKey struct{}
+
+// WithLogger returns a context that carries the logger, e.g. with the ID of the request being handled.
+func WithLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the context, or the default logger when it has none.
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return logger
+	}
+	return defaultLogger
+}
+
+// RequestID returns the ID a client sent along, as long as it is short and printable, or a newly generated one.
+func RequestID(sent string) string {
+	if sent != "" && len(sent) <= maxRequestIDLength && isPrintable(sent) {
+		return sent
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
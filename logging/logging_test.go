@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestLinesAreJSONWithPairsInOrder(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := New(&buffer).With("requestId", "abc")
+	logger.Error("Failed", "number", big.NewInt(42), "error", errors.New("disk full"), "attempt", 2)
+
+	line := buffer.String()
+	var actual map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &actual); err != nil {
+		t.Fatalf("Expected a JSON line, but got %q: %v", line, err)
+	}
+	if actual["level"] != LevelError || actual["msg"] != "Failed" || actual["requestId"] != "abc" ||
+		actual["number"] != "42" || actual["error"] != "disk full" || actual["attempt"] != 2.0 || actual["time"] == nil {
+		t.Errorf("Unexpected line %s", line)
+	}
+	if !strings.HasSuffix(line, `"requestId":"abc","number":"42","error":"disk full","attempt":2}`+"\n") {
+		t.Errorf("Expected the pairs in the order they were given, but got %s", line)
+	}
+}
+
+func TestValueWithoutKey(t *testing.T) {
+	var buffer bytes.Buffer
+	New(&buffer).Info("Odd", 42, "key")
+	if !strings.HasSuffix(buffer.String(), `"!BADKEY":42,"key":null}`+"\n") {
+		t.Errorf("Expected a bad key and a key without value, but got %s", buffer.String())
+	}
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Default() {
+		t.Error("Expected the default logger without one in the context")
+	}
+	logger := New(&bytes.Buffer{})
+	if FromContext(WithLogger(context.Background(), logger)) != logger {
+		t.Error("Expected the logger in the context")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if actual := RequestID("from-the-client"); actual != "from-the-client" {
+		t.Errorf("Expected the ID that was sent, but got %q", actual)
+	}
+	for _, sent := range []string{"", "new\nline", strings.Repeat("x", maxRequestIDLength+1)} {
+		actual := RequestID(sent)
+		if actual == sent || len(actual) != 32 {
+			t.Errorf("Expected a generated ID instead of %q, but got %q", sent, actual)
+		}
+	}
+	if RequestID("") == RequestID("") {
+		t.Error("Expected generated IDs to differ")
+	}
+}
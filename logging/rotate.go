@@ -0,0 +1,189 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotatedTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// RotatingFile is a log file that is rotated once it grows beyond maxSize bytes, or on the first write after interval passed.
+// A rotated file is renamed to e.g. server-2021-03-01T12-00-00.000000000.log and gzipped in the background,
+// after which only the newest retain of them are kept. A maxSize, interval or retain of 0 turns that off.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	retain   int
+	now      func() time.Time
+
+	mutex       sync.Mutex
+	file        *os.File
+	size        int64
+	opened      time.Time
+	compressing sync.WaitGroup
+}
+
+func OpenRotatingFile(path string, maxSize int64, interval time.Duration, retain int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, interval: interval, retain: retain, now: time.Now}
+	return f, f.open()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		// Opening the file again failed when it was last rotated
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	var rotateErr error
+	if f.size > 0 && f.due(int64(len(p))) {
+		// A file that can't be rotated is still written to, rather than losing what is logged
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (f *RotatingFile) due(writing int64) bool {
+	return (f.maxSize > 0 && f.size+writing > f.maxSize) || (f.interval > 0 && f.now().Sub(f.opened) >= f.interval)
+}
+
+// Reopen closes the file and opens it again, so it is created again after it was moved, e.g. by logrotate.
+func (f *RotatingFile) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return f.reopen(err)
+		}
+	}
+	return f.reopen(nil)
+}
+
+// Rotate starts a new file, whether it is due or not.
+func (f *RotatingFile) Rotate() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.rotate()
+}
+
+func (f *RotatingFile) rotate() error {
+	if f.file == nil {
+		return f.reopen(nil)
+	}
+	if err := f.file.Close(); err != nil {
+		// Closing releases the file even when it fails, it is rotated the next time it is due
+		return f.reopen(err)
+	}
+	extension := filepath.Ext(f.path)
+	rotated := strings.TrimSuffix(f.path, extension) + "-" + f.now().UTC().Format(rotatedTimeFormat) + extension
+	if err := os.Rename(f.path, rotated); err != nil {
+		return f.reopen(err)
+	}
+	f.compressing.Add(1)
+	go func() {
+		defer f.compressing.Done()
+		if err := compress(rotated); err != nil {
+			Error("Failed to compress rotated log", "file", rotated, "error", err)
+			return
+		}
+		f.prune()
+	}()
+	return f.reopen(nil)
+}
+
+// reopen opens the file after it was closed and returns err, or the error opening it.
+// When opening fails there is no file, and the next Write tries again.
+func (f *RotatingFile) reopen(err error) error {
+	if openErr := f.open(); openErr != nil {
+		f.file = nil
+		return openErr
+	}
+	return err
+}
+
+func compress(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zipper := gzip.NewWriter(target)
+	if _, err := io.Copy(zipper, source); err != nil {
+		target.Close()
+		return err
+	}
+	if err := zipper.Close(); err != nil {
+		target.Close()
+		return err
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune removes the oldest rotated files, their names sort by the time they were rotated.
+func (f *RotatingFile) prune() {
+	if f.retain <= 0 {
+		return
+	}
+	extension := filepath.Ext(f.path)
+	rotated, err := filepath.Glob(strings.TrimSuffix(f.path, extension) + "-*" + extension + ".gz")
+	if err != nil || len(rotated) <= f.retain {
+		return
+	}
+	sort.Strings(rotated)
+	for _, old := range rotated[:len(rotated)-f.retain] {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			Error("Failed to remove rotated log", "file", old, "error", err)
+		}
+	}
+}
+
+// Close waits for rotated files to be compressed. That can log to this file as well, so it doesn't hold the lock meanwhile.
+func (f *RotatingFile) Close() error {
+	f.compressing.Wait()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
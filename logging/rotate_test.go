@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatesBySizeAndKeepsTheNewest(t *testing.T) {
+	folder := t.TempDir()
+	file, err := OpenRotatingFile(filepath.Join(folder, "server.log"), 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		file.compressing.Wait()
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContent(t, filepath.Join(folder, "server.log"), "fourth\n")
+	rotated, err := filepath.Glob(filepath.Join(folder, "server-*.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("Expected to keep 2 rotated files, but got %v", rotated)
+	}
+	assertGzippedContent(t, rotated[0], "second\n")
+	assertGzippedContent(t, rotated[1], "third\n")
+}
+
+func TestRotatesByTime(t *testing.T) {
+	folder := t.TempDir()
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	file := &RotatingFile{path: filepath.Join(folder, "http.log"), interval: time.Hour, now: func() time.Time { return now }}
+	if err := file.open(); err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("before\n"))
+	now = now.Add(59 * time.Minute)
+	file.Write([]byte("still before\n"))
+	now = now.Add(time.Minute)
+	file.Write([]byte("after\n"))
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContent(t, filepath.Join(folder, "http.log"), "after\n")
+	assertGzippedContent(t, filepath.Join(folder, "http-2021-03-01T13-00-00.000000000.log.gz"), "before\nstill before\n")
+}
+
+func TestReopenCreatesMovedFileAgain(t *testing.T) {
+	folder := t.TempDir()
+	path := filepath.Join(folder, "server.log")
+	file, err := OpenRotatingFile(path, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.Write([]byte("old\n"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("new\n"))
+
+	assertContent(t, path+".1", "old\n")
+	assertContent(t, path, "new\n")
+}
+
+func TestKeepsWritingWhenRotatingFails(t *testing.T) {
+	folder := t.TempDir()
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	file := &RotatingFile{path: filepath.Join(folder, "server.log"), maxSize: 10, now: func() time.Time { return now }}
+	if err := file.open(); err != nil {
+		t.Fatal(err)
+	}
+	// A file can't be renamed to a folder that isn't empty
+	blocked := filepath.Join(folder, "server-2021-03-01T12-00-00.000000000.log")
+	if err := os.MkdirAll(filepath.Join(blocked, "in the way"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("first\n"))
+	if err := file.Rotate(); err == nil {
+		t.Error("Expected rotating to fail")
+	}
+	if _, err := file.Write([]byte("second\n")); err == nil {
+		t.Error("Expected the failure to rotate to be reported")
+	}
+	if err := os.RemoveAll(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+	file.compressing.Wait()
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContent(t, filepath.Join(folder, "server.log"), "third\n")
+	assertGzippedContent(t, blocked+".gz", "first\nsecond\n")
+}
+
+func TestKeepsWritingWhenReopeningFails(t *testing.T) {
+	folder := t.TempDir()
+	path := filepath.Join(folder, "server.log")
+	file := &RotatingFile{path: path, now: time.Now}
+	if err := file.open(); err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("first\n"))
+	// Closing fails for a file that is already closed
+	file.file.Close()
+	if err := file.Rotate(); err == nil {
+		t.Error("Expected rotating to fail")
+	}
+	if _, err := file.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, path, "first\nsecond\n")
+
+	// A file can't be opened for writing where a folder is in the way
+	if err := os.Rename(path, filepath.Join(folder, "moved.log")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Reopen(); err == nil {
+		t.Error("Expected reopening to fail")
+	}
+	if _, err := file.Write([]byte("lost\n")); err == nil {
+		t.Error("Expected writing to fail while the file can't be opened")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, path, "third\n")
+}
+
+func assertContent(t *testing.T, path string, expected string) {
+	t.Helper()
+	actual, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("Expected %s to contain %q, but got %q", path, expected, actual)
+	}
+}
+
+func assertGzippedContent(t *testing.T, path string, expected string) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("Expected %s to contain %q, but got %q", path, expected, actual)
+	}
+}
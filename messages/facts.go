@@ -0,0 +1,76 @@
+package messages
+
+import (
+	"io/ioutil"
+	"math/big"
+	"tbp.com/user/hello/primes"
+	"text/template"
+)
+
+// Facts are what a message template can refer to, e.g. "{{.Number}} is {{.Factor}}×{{.Cofactor}}".
+// Factor and Cofactor are nil when no factor could be found cheaply.
+type Facts struct {
+	Number  *big.Int
+	Count   int
+	IsPrime bool
+
+	factor   *big.Int
+	factored bool
+}
+
+// NewFacts looks for the smallest factor of a number that isn't prime right away, so Factor and Cofactor don't each look for it again.
+func NewFacts(number *big.Int, count int, isPrime bool) Facts {
+	f := Facts{Number: number, Count: count, IsPrime: isPrime, factored: true}
+	if !isPrime {
+		f.factor, _ = primes.SmallestFactor(number)
+	}
+	return f
+}
+
+func (f Facts) Factor() *big.Int {
+	if f.factored {
+		return f.factor
+	}
+	factor, found := primes.SmallestFactor(f.Number)
+	if !found {
+		return nil
+	}
+	return factor
+}
+
+func (f Facts) Cofactor() *big.Int {
+	factor := f.Factor()
+	if factor == nil {
+		return nil
+	}
+	return new(big.Int).Quo(f.Number, factor)
+}
+
+func (f Facts) NextPrime() *big.Int {
+	return primes.NextPrime(f.Number)
+}
+
+// noCheapFactor is 4294967311×4294967357, too large to look for its factors.
+var noCheapFactor, _ = new(big.Int).SetString("18446744400127067027", 10)
+
+// exampleFacts are the facts templates of every kind are tried with: a prime,
+// or a composite both with and without a factor that can be found cheaply.
+var exampleFacts = map[string][]Facts{
+	KindPrime:    {{Number: big.NewInt(23), Count: 3, IsPrime: true}},
+	KindNotPrime: {{Number: big.NewInt(22), Count: 3}, {Number: noCheapFactor, Count: 3}},
+}
+
+// parseTemplate also executes the template with the example facts of its kind,
+// so references to unknown facts and facts a number of that kind may not have are found before it is used.
+func parseTemplate(message string, kind string) (*template.Template, error) {
+	parsed, err := template.New("message").Parse(message)
+	if err != nil {
+		return nil, err
+	}
+	for _, facts := range exampleFacts[kind] {
+		if err := parsed.Execute(ioutil.Discard, facts); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
@@ -0,0 +1,5 @@
+package messages
+
+import "tbp.com/user/hello/metrics"
+
+var messagesServed = metrics.NewCounter("hello_feedback_messages_total", "Feedback messages served, by the tier they came from.", "kind", "lower_limit")
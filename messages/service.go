@@ -1,45 +1,171 @@
 package messages
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tbp.com/user/hello/logging"
 	"tbp.com/user/hello/repository"
 	"tbp.com/user/hello/responses"
+	"text/template"
 )
 
+const (
+	KindNotPrime = "notPrime"
+	KindPrime    = "prime"
+)
+
+var defaultMessages = responses.MessageSlice{
+	{LowerLimit: 3, Message: "No, and we already told you so!", Kind: KindNotPrime},
+	{LowerLimit: 0, Message: "No", Kind: KindNotPrime},
+	{LowerLimit: 0, Message: "It is prime. Hurray!", Kind: KindPrime},
+}
+
+// Messages are text/template templates, executed with the Facts of the number that was asked for.
+// Every kind of answer has its own tiers of messages, a message without kind is for numbers that are not prime.
+// Updates are persisted in the background, every update gets a version so an older one never overwrites a newer one.
 type Service struct {
-	repository repository.FileRepository
+	mutex      sync.RWMutex
+	repository repository.Repository
 	responses.Messages
+	templates []*template.Template
+	version   int
+
+	persisting       sync.WaitGroup
+	persistMutex     sync.Mutex
+	persistedVersion int
+	persistError     error
 }
 
-func (m Service) GetMessage(count int) string {
+func (m *Service) GetMessage(ctx context.Context, facts Facts) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	kind := KindNotPrime
+	if facts.IsPrime {
+		kind = KindPrime
+	}
 	messages := m.Messages.Messages
-	for _, message := range messages {
-		if count >= message.LowerLimit {
+	i := -1
+	for j, message := range messages {
+		if message.Kind != kind {
+			continue
+		}
+		i = j
+		if facts.Count >= message.LowerLimit {
+			break
+		}
+	}
+	messagesServed.Inc(kind, strconv.Itoa(messages[i].LowerLimit))
+	var message strings.Builder
+	if err := m.templates[i].Execute(&message, facts); err != nil {
+		logging.FromContext(ctx).Error("Failed to execute message template", "component", "messages", "kind", kind, "lowerLimit", messages[i].LowerLimit, "error", err)
+		return defaultMessage(kind)
+	}
+	return message.String()
+}
+
+// defaultMessage is what is answered when a template fails, rather than its source.
+func defaultMessage(kind string) string {
+	for _, message := range defaultMessages {
+		if message.Kind == kind && message.LowerLimit == 0 {
 			return message.Message
 		}
 	}
-	return messages[len(messages)-1].Message
+	return ""
 }
 
-func (m *Service) Update(messages responses.Messages) error {
-	err := validate(messages.Messages)
+// Update replaces the tiers of every kind that is present in messages, other kinds are left as they are.
+// Failing to persist them is logged with the logger of the context.
+func (m *Service) Update(ctx context.Context, messages responses.Messages) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	provided := withKinds(messages.Messages)
+	updated := append(responses.MessageSlice{}, provided...)
+	for _, message := range m.Messages.Messages {
+		if !containsKind(provided, message.Kind) {
+			updated = append(updated, message)
+		}
+	}
+	err := validate(updated)
 	if err != nil {
 		return err
 	}
-	sort.Sort(messages.Messages)
-	m.Messages = messages
-	go m.persist()
+	sort.Sort(updated)
+	templates, err := parseTemplates(updated)
+	if err != nil {
+		return err
+	}
+	m.Messages = responses.Messages{Messages: updated}
+	m.templates = templates
+	m.version++
+	m.persisting.Add(1)
+	go func(version int, messages responses.Messages) {
+		defer m.persisting.Done()
+		if err := m.persist(version, messages); err != nil {
+			logging.FromContext(ctx).Error("Failed to persist messages", "component", "messages", "version", version, "error", err)
+		}
+	}(m.version, m.Messages)
 	return nil
 }
 
+func withKinds(messages responses.MessageSlice) responses.MessageSlice {
+	var kinded responses.MessageSlice
+	for _, message := range messages {
+		if message.Kind == "" {
+			message.Kind = KindNotPrime
+		}
+		kinded = append(kinded, message)
+	}
+	return kinded
+}
+
+func containsKind(messages responses.MessageSlice, kind string) bool {
+	for _, message := range messages {
+		if message.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTemplates(messages responses.MessageSlice) ([]*template.Template, error) {
+	var templates []*template.Template
+	for _, message := range messages {
+		parsed, err := parseTemplate(message.Message, message.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("must contain only valid templates, found %q: %v", message.Message, err)
+		}
+		templates = append(templates, parsed)
+	}
+	return templates, nil
+}
+
 func validate(messages responses.MessageSlice) error {
+	for _, message := range messages {
+		if message.Kind != KindNotPrime && message.Kind != KindPrime {
+			return fmt.Errorf("must contain only kinds %q and %q, found %q in %+v", KindNotPrime, KindPrime, message.Kind, message)
+		}
+	}
+	for _, kind := range []string{KindNotPrime, KindPrime} {
+		if err := validateTiers(kind, messages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTiers(kind string, messages responses.MessageSlice) error {
 	defaultFound := false
 	for _, message := range messages {
+		if message.Kind != kind {
+			continue
+		}
 		if message.LowerLimit == 0 {
 			if defaultFound {
-				return fmt.Errorf("must contain only 1 element with lower limit 0, found multiple in %+v", message)
+				return fmt.Errorf("must contain only 1 element of kind %s with lower limit 0, found multiple in %+v", kind, message)
 			}
 			defaultFound = true
 		}
@@ -48,38 +174,73 @@ func validate(messages responses.MessageSlice) error {
 		}
 	}
 	if !defaultFound {
-		return fmt.Errorf("must contain element with lower limit 0, found none in %+v", messages)
+		return fmt.Errorf("must contain element of kind %s with lower limit 0, found none in %+v", kind, messages)
 	}
 	return nil
 }
 
-func (m Service) Get() interface{} {
+func (m *Service) Get() interface{} {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	return m.Messages
 }
 
-func (m Service) persist() {
-	err := m.repository.Persist(m.Messages)
-	if err != nil {
-		log.Println(err)
+// Close waits for updates that are still being persisted, and persists the latest messages if that failed.
+func (m *Service) Close() error {
+	m.persisting.Wait()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.persist(m.version, m.Messages)
+}
+
+func (m *Service) persist(version int, messages responses.Messages) error {
+	m.persistMutex.Lock()
+	defer m.persistMutex.Unlock()
+	if version <= m.persistedVersion {
+		return nil
 	}
+	err := m.repository.Persist(messages)
+	if err == nil {
+		m.persistedVersion = version
+	}
+	m.persistError = err
+	return err
+}
+
+// LastPersistError returns why the messages could not be persisted the last time, or nil if that succeeded.
+// It waits for a write that is in progress.
+func (m *Service) LastPersistError() error {
+	m.persistMutex.Lock()
+	defer m.persistMutex.Unlock()
+	return m.persistError
 }
 
-func Setup(folderName string) (*Service, error) {
-	repository, err := repository.Initialize(folderName, "messages")
+func Setup(repository repository.Repository) (*Service, error) {
 	var messages responses.Messages
-	err = repository.ReadAll(&messages)
-
-	if messages.Messages == nil && err == nil {
-		messages = responses.Messages{
-			Messages: responses.MessageSlice{
-				{3, "No, and we already told you so!"},
-				{0, "No"},
-			},
+	err := repository.ReadAll(&messages)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := withKinds(messages.Messages)
+	messages.Messages = append(responses.MessageSlice{}, stored...)
+	for _, message := range defaultMessages {
+		if !containsKind(stored, message.Kind) {
+			messages.Messages = append(messages.Messages, message)
 		}
+	}
+	// Kinds that were not stored yet, e.g. because they didn't exist yet when the messages were stored, get the defaults
+	if len(messages.Messages) != len(stored) {
 		err = repository.Persist(messages)
 	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(messages.Messages)
+	templates, err := parseTemplates(messages.Messages)
 	return &Service{
 		repository: repository,
 		Messages:   messages,
+		templates:  templates,
 	}, err
 }
@@ -1,58 +1,181 @@
 package messages
 
 import (
+	"context"
+	"math/big"
+	"tbp.com/user/hello/repository"
 	"tbp.com/user/hello/responses"
 	"testing"
 )
 
 func TestUpdatesOnlyWith0Present(t *testing.T) {
-	service, err := Setup("test_data")
+	service, err := Setup(repository.InitializeMemory())
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Run("Updates without error when one default element present", func(t *testing.T) {
 		messages := responses.Messages{
 			Messages: []responses.Message{
-				{0, "No"},
-				{3, "No, and we already told you so!"},
+				{LowerLimit: 0, Message: "No"},
+				{LowerLimit: 3, Message: "No, and we already told you so!"},
 			},
 		}
-		if service.Update(messages) != nil {
+		if service.Update(context.Background(), messages) != nil {
 			t.Error("Expected no error")
 		}
 	})
 	t.Run("Can update only when default element present", func(t *testing.T) {
 		messages := responses.Messages{
 			Messages: []responses.Message{
-				{3, "No, and we already told you so!"},
+				{LowerLimit: 3, Message: "No, and we already told you so!"},
 			},
 		}
-		if service.Update(messages) == nil {
+		if service.Update(context.Background(), messages) == nil {
 			t.Error("Expected an error")
 		}
 	})
 	t.Run("Can update only when single default element present", func(t *testing.T) {
 		messages := responses.Messages{
 			Messages: []responses.Message{
-				{0, "No"},
-				{0, "No"},
-				{3, "No, and we already told you so!"},
+				{LowerLimit: 0, Message: "No"},
+				{LowerLimit: 0, Message: "No"},
+				{LowerLimit: 3, Message: "No, and we already told you so!"},
 			},
 		}
-		if service.Update(messages) == nil {
+		if service.Update(context.Background(), messages) == nil {
 			t.Error("Expected an error")
 		}
 	})
 	t.Run("Can update only when all lower limits are positive", func(t *testing.T) {
 		messages := responses.Messages{
 			Messages: []responses.Message{
-				{0, "No"},
-				{-1, "No, please"},
-				{3, "No, and we already told you so!"},
+				{LowerLimit: 0, Message: "No"},
+				{LowerLimit: -1, Message: "No, please"},
+				{LowerLimit: 3, Message: "No, and we already told you so!"},
 			},
 		}
-		if service.Update(messages) == nil {
+		if service.Update(context.Background(), messages) == nil {
 			t.Error("Expected an error")
 		}
 	})
 }
+
+func TestMessagesAreTemplates(t *testing.T) {
+	service, err := Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.Update(context.Background(), responses.Messages{
+		Messages: []responses.Message{
+			{LowerLimit: 0, Message: "No, {{.Number}} is {{.Factor}}×{{.Cofactor}}, and you've asked {{.Count}} times"},
+			{LowerLimit: 3, Message: "Still no, but {{.NextPrime}} is"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		facts    Facts
+		expected string
+	}{
+		{Facts{Number: big.NewInt(22), Count: 2}, "No, 22 is 2×11, and you've asked 2 times"},
+		{Facts{Number: big.NewInt(24), Count: 3}, "Still no, but 29 is"},
+		{NewFacts(big.NewInt(35), 1, false), "No, 35 is 5×7, and you've asked 1 times"},
+	}
+	for _, testCase := range testCases {
+		if actual := service.GetMessage(context.Background(), testCase.facts); actual != testCase.expected {
+			t.Errorf("Expected %q, but got %q", testCase.expected, actual)
+		}
+	}
+}
+
+func TestUpdatesOnlyWithValidTemplates(t *testing.T) {
+	service, err := Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"No {{.Number", "No {{.Colour}}"} {
+		t.Run("Rejects "+message, func(t *testing.T) {
+			err := service.Update(context.Background(), responses.Messages{Messages: []responses.Message{{LowerLimit: 0, Message: message}}})
+			if err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestTemplatesAreTriedWithFactsOfTheirKind(t *testing.T) {
+	service, err := Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []responses.Message{
+		{LowerLimit: 0, Message: "Yes, {{.Cofactor.Int64}}", Kind: KindPrime},
+		{LowerLimit: 0, Message: "No, {{.Factor.Int64}}", Kind: KindNotPrime},
+	} {
+		t.Run("Rejects "+message.Message+" for "+message.Kind, func(t *testing.T) {
+			if service.Update(context.Background(), responses.Messages{Messages: []responses.Message{message}}) == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	err = service.Update(context.Background(), responses.Messages{Messages: []responses.Message{
+		{LowerLimit: 0, Message: "Yes{{if eq .Count 7}}, {{.Cofactor.Int64}}{{end}}", Kind: KindPrime},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := service.GetMessage(context.Background(), Facts{Number: big.NewInt(5), Count: 7, IsPrime: true}); actual != "It is prime. Hurray!" {
+		t.Errorf("Expected the default message when the template fails, but got %q", actual)
+	}
+}
+
+func TestSetupAddsMissingKinds(t *testing.T) {
+	stored := repository.InitializeMemory()
+	err := stored.Persist(responses.Messages{Messages: []responses.Message{{LowerLimit: 0, Message: "Nope"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := Setup(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := service.GetMessage(context.Background(), Facts{Number: big.NewInt(4), Count: 1}); actual != "Nope" {
+		t.Errorf("Expected %q, but got %q", "Nope", actual)
+	}
+	if actual := service.GetMessage(context.Background(), Facts{Number: big.NewInt(5), Count: 1, IsPrime: true}); actual != "It is prime. Hurray!" {
+		t.Errorf("Expected %q, but got %q", "It is prime. Hurray!", actual)
+	}
+	var persisted responses.Messages
+	if err := stored.ReadAll(&persisted); err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted.Messages) != 2 {
+		t.Errorf("Expected the default prime message to be persisted, but got %+v", persisted)
+	}
+}
+
+func TestCloseWaitsForTheLatestMessagesToBePersisted(t *testing.T) {
+	stored := repository.InitializeMemory()
+	service, err := Setup(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"No", "Nope", "Nah"} {
+		err := service.Update(context.Background(), responses.Messages{Messages: []responses.Message{{LowerLimit: 0, Message: message}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var persisted responses.Messages
+	if err := stored.ReadAll(&persisted); err != nil {
+		t.Fatal(err)
+	}
+	if persisted.Messages[0].Message != "Nah" {
+		t.Errorf("Expected the latest messages to be persisted, but got %+v", persisted)
+	}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounterWritesEveryCombinationOfLabels(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "route", "code")
+	c.Inc("/primes", "200")
+	c.Inc("/primes", "200")
+	c.Add(3, "/say \"hi\"", "404")
+	var buffer bytes.Buffer
+	c.write(&buffer)
+	expected := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/primes",code="200"} 2
+test_requests_total{route="/say \"hi\"",code="404"} 3
+`
+	if buffer.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, buffer.String())
+	}
+	if c.Value() != 5 {
+		t.Errorf("Expected a total of 5, but got %v", c.Value())
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+	var buffer bytes.Buffer
+	h.write(&buffer)
+	expected := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 2.55
+test_duration_seconds_count 3
+`
+	if buffer.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, buffer.String())
+	}
+}
+
+func TestGaugeFuncIsEvaluatedWhenWritten(t *testing.T) {
+	value := 1.0
+	g := NewGaugeFunc("test_ratio", "Ratio.", func() float64 { return value })
+	value = 0.25
+	var buffer bytes.Buffer
+	g.write(&buffer)
+	if !strings.HasSuffix(buffer.String(), "test_ratio 0.25\n") {
+		t.Errorf("Expected the current value of the function, but got\n%s", buffer.String())
+	}
+}
+
+func TestWrongNumberOfLabelValuesPanics(t *testing.T) {
+	c := NewCounter("test_labelled_total", "Labelled.", "kind")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic")
+		}
+	}()
+	c.Inc()
+}
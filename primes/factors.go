@@ -0,0 +1,170 @@
+package primes
+
+import (
+	"context"
+	"math/big"
+	"sort"
+)
+
+const (
+	trialDivisionLimit = 1 << 16
+	rhoAttempts        = 3
+	// rhoIterations is the budget of Pollard's rho for numbers up to 64 bits. Every step on a larger number costs
+	// about the square of its size, so the budget shrinks with that to keep the time spent on a single number bounded.
+	rhoIterations = 1 << 18
+)
+
+var (
+	smallPrimes = sieve(trialDivisionLimit)
+	one         = big.NewInt(1)
+)
+
+type Factor struct {
+	Prime    *big.Int
+	Exponent int
+}
+
+// Factorize returns the prime factors of number in ascending order, using trial division by small primes
+// and Pollard's rho for what is left. Composites that Pollard's rho can't split within its budget
+// are multiplied into remainder, which is nil when the factorization is complete.
+// Once ctx is done Pollard's rho gives up, so what is left ends up in remainder as well.
+func Factorize(ctx context.Context, number *big.Int) (factors []Factor, remainder *big.Int) {
+	if number.Cmp(one) <= 0 {
+		return nil, nil
+	}
+	exponents := make(map[string]*Factor)
+	add := func(prime *big.Int) {
+		key := prime.String()
+		if exponents[key] == nil {
+			exponents[key] = &Factor{Prime: new(big.Int).Set(prime)}
+		}
+		exponents[key].Exponent++
+	}
+
+	rest := new(big.Int).Set(number)
+	quotient, modulus := new(big.Int), new(big.Int)
+	for _, p := range smallPrimes {
+		if rest.Cmp(one) == 0 {
+			break
+		}
+		prime := big.NewInt(int64(p))
+		for {
+			quotient.QuoRem(rest, prime, modulus)
+			if modulus.Sign() != 0 {
+				break
+			}
+			add(prime)
+			rest.Set(quotient)
+		}
+	}
+
+	var split func(n *big.Int)
+	split = func(n *big.Int) {
+		if n.Cmp(one) == 0 {
+			return
+		}
+		if Check(n).IsPrime {
+			add(n)
+			return
+		}
+		divisor := pollardRho(ctx, n)
+		if divisor == nil {
+			if remainder == nil {
+				remainder = big.NewInt(1)
+			}
+			remainder.Mul(remainder, n)
+			return
+		}
+		split(divisor)
+		split(new(big.Int).Quo(n, divisor))
+	}
+	split(rest)
+
+	for _, factor := range exponents {
+		factors = append(factors, *factor)
+	}
+	sort.Slice(factors, func(i, j int) bool { return factors[i].Prime.Cmp(factors[j].Prime) < 0 })
+	return factors, remainder
+}
+
+// SmallestFactor returns the smallest prime factor of a composite number when it can be found cheaply:
+// by trial division, or by a complete factorization of numbers below 2⁶⁴.
+func SmallestFactor(number *big.Int) (*big.Int, bool) {
+	if number.Cmp(one) <= 0 {
+		return nil, false
+	}
+	modulus := new(big.Int)
+	for _, p := range smallPrimes {
+		prime := big.NewInt(int64(p))
+		if prime.Cmp(number) >= 0 {
+			return nil, false
+		}
+		if modulus.Mod(number, prime).Sign() == 0 {
+			return prime, true
+		}
+	}
+	if number.BitLen() > 64 {
+		return nil, false
+	}
+	factors, remainder := Factorize(context.Background(), number)
+	if remainder != nil || len(factors) == 0 || factors[0].Prime.Cmp(number) == 0 {
+		return nil, false
+	}
+	return factors[0].Prime, true
+}
+
+func pollardRho(ctx context.Context, n *big.Int) *big.Int {
+	iterations := rhoIterations
+	if bits := n.BitLen(); bits > 64 {
+		iterations = rhoIterations * 64 * 64 / (bits * bits)
+	}
+	for c := int64(1); c <= rhoAttempts && ctx.Err() == nil; c++ {
+		if divisor := brent(ctx, n, big.NewInt(c), iterations); divisor != nil {
+			return divisor
+		}
+	}
+	return nil
+}
+
+// brent looks for a non-trivial divisor of the composite n with Brent's variant of Pollard's rho,
+// iterating x² + c mod n. It gives up with nil after about iterations steps, when the cycle is found without a divisor,
+// or when ctx is done.
+func brent(ctx context.Context, n *big.Int, c *big.Int, iterations int) *big.Int {
+	next := func(x *big.Int) {
+		x.Mul(x, x).Add(x, c).Mod(x, n)
+	}
+	y, x, saved := big.NewInt(2), new(big.Int), new(big.Int)
+	product, difference, divisor := big.NewInt(1), new(big.Int), big.NewInt(1)
+	const batch = 128
+	for r := 1; divisor.Cmp(one) == 0; r *= 2 {
+		if r > iterations {
+			return nil
+		}
+		x.Set(y)
+		for i := 0; i < r; i++ {
+			next(y)
+		}
+		for k := 0; k < r && divisor.Cmp(one) == 0; k += batch {
+			if ctx.Err() != nil {
+				return nil
+			}
+			saved.Set(y)
+			for i := 0; i < batch && i < r-k; i++ {
+				next(y)
+				product.Mul(product, difference.Sub(x, y).Abs(difference)).Mod(product, n)
+			}
+			divisor.GCD(nil, nil, product, n)
+		}
+	}
+	if divisor.Cmp(n) == 0 {
+		// The batched product overshot, step back one iteration at a time from the last saved point.
+		for divisor.Cmp(one) == 0 || divisor.Cmp(n) == 0 {
+			next(saved)
+			divisor.GCD(nil, nil, difference.Sub(x, saved).Abs(difference), n)
+			if divisor.Cmp(n) == 0 {
+				return nil
+			}
+		}
+	}
+	return divisor
+}
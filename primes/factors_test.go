@@ -0,0 +1,79 @@
+package primes
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestFactorize(t *testing.T) {
+	testCases := []struct {
+		number   string
+		expected string
+	}{
+		{"1", "[]"},
+		{"2", "[2^1]"},
+		{"360", "[2^3 3^2 5^1]"},
+		{"4294967297", "[641^1 6700417^1]"},
+		{"18446744073709551615", "[3^1 5^1 17^1 257^1 641^1 65537^1 6700417^1]"},
+		{"1000000016000000063", "[1000000007^1 1000000009^1]"},
+		{"170141183460469231731687303715884105727", "[170141183460469231731687303715884105727^1]"},
+	}
+	for _, testCase := range testCases {
+		t.Run("Factorizing "+testCase.number, func(t *testing.T) {
+			number, _ := new(big.Int).SetString(testCase.number, 10)
+			factors, remainder := Factorize(context.Background(), number)
+			if remainder != nil {
+				t.Fatalf("Expected a complete factorization, but %s remained", remainder)
+			}
+			if actual := format(factors); actual != testCase.expected {
+				t.Errorf("Expected %s, but got %s", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFactorizeGivesUpWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	number, _ := new(big.Int).SetString("1000000016000000063", 10)
+	factors, remainder := Factorize(ctx, number)
+	if len(factors) != 0 || remainder == nil || remainder.Cmp(number) != 0 {
+		t.Errorf("Expected the whole number to remain, but got %s and %s", format(factors), remainder)
+	}
+}
+
+func TestSmallestFactor(t *testing.T) {
+	testCases := []struct {
+		number   string
+		expected string
+		found    bool
+	}{
+		{"1", "", false},
+		{"97", "", false},
+		{"22", "2", true},
+		{"1000000016000000063", "1000000007", true},
+		{"170141183460469231731687303715884105729", "3", true},
+	}
+	for _, testCase := range testCases {
+		t.Run("Smallest factor of "+testCase.number, func(t *testing.T) {
+			number, _ := new(big.Int).SetString(testCase.number, 10)
+			factor, found := SmallestFactor(number)
+			if found != testCase.found {
+				t.Fatalf("Expected found to be %t, but got %t", testCase.found, found)
+			}
+			if found && factor.String() != testCase.expected {
+				t.Errorf("Expected %s, but got %s", testCase.expected, factor)
+			}
+		})
+	}
+}
+
+func format(factors []Factor) string {
+	formatted := []string{}
+	for _, factor := range factors {
+		formatted = append(formatted, fmt.Sprintf("%s^%d", factor.Prime, factor.Exponent))
+	}
+	return fmt.Sprint(formatted)
+}
@@ -0,0 +1,73 @@
+package primes
+
+import (
+	"math"
+	"math/big"
+)
+
+// MillerRabinRounds is the number of Miller-Rabin rounds used on top of Baillie-PSW for numbers of 2⁶⁴ and up.
+const MillerRabinRounds = 20
+
+const (
+	VerdictPrime         = "prime"
+	VerdictProbablyPrime = "probably prime"
+	VerdictNotPrime      = "not prime"
+)
+
+type Primality struct {
+	IsPrime bool
+	// Rounds is the number of Miller-Rabin rounds used, 0 when the answer is exact.
+	Rounds int
+	// Certainty is the lower bound of the probability that the answer is correct.
+	Certainty float64
+}
+
+func Check(number *big.Int) Primality {
+	rounds := roundsFor(number)
+	return Describe(number, number.ProbablyPrime(rounds))
+}
+
+// Describe tells how certain an earlier check of number, which resulted in isPrime, is.
+// A composite answer is always certain. ProbablyPrime is exact below 2⁶⁴, above that
+// every Miller-Rabin round lowers the chance of a composite passing by a factor of 4.
+func Describe(number *big.Int, isPrime bool) Primality {
+	rounds := roundsFor(number)
+	if !isPrime || rounds == 0 {
+		return Primality{IsPrime: isPrime, Certainty: 1}
+	}
+	return Primality{IsPrime: true, Rounds: rounds, Certainty: 1 - math.Pow(4, -float64(rounds))}
+}
+
+func (p Primality) Verdict() string {
+	switch {
+	case !p.IsPrime:
+		return VerdictNotPrime
+	case p.Rounds > 0:
+		return VerdictProbablyPrime
+	default:
+		return VerdictPrime
+	}
+}
+
+func roundsFor(number *big.Int) int {
+	if number.BitLen() <= 64 {
+		return 0
+	}
+	return MillerRabinRounds
+}
+
+// NextPrime returns the smallest prime, or probable prime, greater than number.
+func NextPrime(number *big.Int) *big.Int {
+	two := big.NewInt(2)
+	if number.Cmp(two) < 0 {
+		return two
+	}
+	candidate := new(big.Int).Add(number, one)
+	if candidate.Bit(0) == 0 {
+		candidate.Add(candidate, one)
+	}
+	for !Check(candidate).IsPrime {
+		candidate.Add(candidate, two)
+	}
+	return candidate
+}
@@ -0,0 +1,38 @@
+package primes
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		number  string
+		verdict string
+		rounds  int
+	}{
+		{"97", VerdictPrime, 0},
+		{"18446744073709551557", VerdictPrime, 0},
+		{"18446744073709551615", VerdictNotPrime, 0},
+		{"170141183460469231731687303715884105727", VerdictProbablyPrime, MillerRabinRounds},
+		{"170141183460469231731687303715884105729", VerdictNotPrime, 0},
+	}
+	for _, testCase := range testCases {
+		t.Run("Checking "+testCase.number, func(t *testing.T) {
+			number, _ := new(big.Int).SetString(testCase.number, 10)
+			actual := Check(number)
+			if actual.Verdict() != testCase.verdict {
+				t.Errorf("Expected %q, but got %q", testCase.verdict, actual.Verdict())
+			}
+			if actual.Rounds != testCase.rounds {
+				t.Errorf("Expected %d rounds, but got %d", testCase.rounds, actual.Rounds)
+			}
+			if testCase.rounds == 0 && actual.Certainty != 1 {
+				t.Errorf("Expected certainty 1, but got %v", actual.Certainty)
+			}
+			if testCase.rounds > 0 && (actual.Certainty >= 1 || actual.Certainty < 0.999999) {
+				t.Errorf("Expected certainty just below 1, but got %v", actual.Certainty)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package primes
+
+import "strconv"
+
+// MaxRangeLimit is the highest upper bound Range accepts, keeping the base primes of the sieve small.
+// It is 2^40 where int has 64 bits, and 2^30 where it has 32 bits, so the last segment can't overflow.
+const MaxRangeLimit = 1<<40*(strconv.IntSize/64) + 1<<30*(1-strconv.IntSize/64)
+
+const segmentSize = 1 << 15
+
+// Range returns at most limit primes between from and to (both inclusive), in ascending order.
+func Range(from int, to int, limit int) []int {
+	found := []int{}
+	if limit <= 0 {
+		return found
+	}
+	Each(from, to, func(prime int) bool {
+		found = append(found, prime)
+		return len(found) < limit
+	})
+	return found
+}
+
+// Each calls found with the primes between from and to (both inclusive) in ascending order, until it returns false.
+// It uses a segmented sieve of Eratosthenes, so only one segment is kept in memory at a time
+// and sieving stops as soon as no more primes are wanted.
+func Each(from int, to int, found func(prime int) bool) {
+	if from < 2 {
+		from = 2
+	}
+	if to > MaxRangeLimit {
+		to = MaxRangeLimit
+	}
+	if to < from {
+		return
+	}
+	basePrimes := sieve(squareRoot(to))
+	composite := make([]bool, segmentSize)
+	for low := from; low <= to; low += segmentSize {
+		high := low + segmentSize - 1
+		if high > to {
+			high = to
+		}
+		segment := composite[:high-low+1]
+		for i := range segment {
+			segment[i] = false
+		}
+		for _, p := range basePrimes {
+			if p*p > high {
+				break
+			}
+			start := (low + p - 1) / p * p
+			if start < p*p {
+				start = p * p
+			}
+			for multiple := start; multiple <= high; multiple += p {
+				segment[multiple-low] = true
+			}
+		}
+		for i, isComposite := range segment {
+			if !isComposite && !found(low+i) {
+				return
+			}
+		}
+	}
+}
+
+func sieve(limit int) []int {
+	var found []int
+	composite := make([]bool, limit+1)
+	for i := 2; i <= limit; i++ {
+		if composite[i] {
+			continue
+		}
+		found = append(found, i)
+		for multiple := i * i; multiple <= limit; multiple += i {
+			composite[multiple] = true
+		}
+	}
+	return found
+}
+
+func squareRoot(number int) int {
+	root := 0
+	// The square of the first candidate has to fit in an int
+	for bit := 1 << (strconv.IntSize/2 - 1); bit > 0; bit >>= 1 {
+		candidate := root | bit
+		if candidate*candidate <= number {
+			root = candidate
+		}
+	}
+	return root
+}
@@ -0,0 +1,67 @@
+package primes
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRangeMatchesIsPrime(t *testing.T) {
+	testCases := []struct {
+		from int
+		to   int
+	}{
+		{0, 100},
+		{90, 200},
+		{65000, 140000},
+		{1000000000, 1000001000},
+	}
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("Primes between %d and %d", testCase.from, testCase.to), func(t *testing.T) {
+			var expected []int
+			for number := testCase.from; number <= testCase.to; number++ {
+				if IsPrime(number) {
+					expected = append(expected, number)
+				}
+			}
+			actual := Range(testCase.from, testCase.to, testCase.to)
+			if len(actual) != len(expected) {
+				t.Fatalf("Expected %d primes, but got %d", len(expected), len(actual))
+			}
+			for i := range expected {
+				if actual[i] != expected[i] {
+					t.Errorf("Expected %d at position %d, but got %d", expected[i], i, actual[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRangeStopsAtLimit(t *testing.T) {
+	actual := Range(0, 100, 4)
+	expected := []int{2, 3, 5, 7}
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, actual)
+		}
+	}
+}
+
+func TestRangeIsEmptyWhenBoundsAreReversed(t *testing.T) {
+	if actual := Range(100, 10, 10); len(actual) != 0 {
+		t.Errorf("Expected no primes, but got %v", actual)
+	}
+}
+
+func TestEachStopsWhenToldTo(t *testing.T) {
+	var found []int
+	Each(100, MaxRangeLimit, func(prime int) bool {
+		found = append(found, prime)
+		return prime < 109
+	})
+	if fmt.Sprint(found) != "[101 103 107 109]" {
+		t.Errorf("Expected to stop after 109, but got %v", found)
+	}
+}
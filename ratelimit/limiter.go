@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"tbp.com/user/hello/metrics"
+	"tbp.com/user/hello/responses"
+	"time"
+)
+
+// sweepInterval is how often buckets that filled up again are forgotten, so the limiter doesn't grow with every client and number.
+const sweepInterval = time.Minute
+
+var limited = metrics.NewCounter("hello_rate_limited_total", "Requests refused because a bucket was empty, by bucket.", "bucket")
+
+// Limits are in requests per minute. A per minute of 0 turns that limit off, a burst is at least 1.
+type Limits struct {
+	PerMinute       int
+	Burst           int
+	RepeatPerMinute int
+	RepeatBurst     int
+}
+
+// Limiter keeps a token bucket per client, and a stricter one per client for every number it asks for,
+// so asking for the same number over and over runs out sooner than asking for different numbers.
+type Limiter struct {
+	limits Limits
+	now    func() time.Time
+
+	mutex     sync.Mutex
+	clients   map[string]*bucket
+	repeats   map[repeat]*bucket
+	lastSweep time.Time
+}
+
+type repeat struct {
+	client string
+	number string
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func New(limits Limits) *Limiter {
+	if limits.Burst < 1 {
+		limits.Burst = 1
+	}
+	if limits.RepeatBurst < 1 {
+		limits.RepeatBurst = 1
+	}
+	return &Limiter{
+		limits:  limits,
+		now:     time.Now,
+		clients: make(map[string]*bucket),
+		repeats: make(map[repeat]*bucket),
+	}
+}
+
+// Allow takes a token from the buckets of the client and of the number it asks for, but only when both have one.
+// Otherwise it tells how long the client has to wait before trying again.
+func (l *Limiter) Allow(client string, number string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+	var buckets []*bucket
+	var wait time.Duration
+	if l.limits.PerMinute > 0 {
+		b := l.clients[client]
+		if b == nil {
+			b = &bucket{tokens: float64(l.limits.Burst), updated: now}
+			l.clients[client] = b
+		}
+		b.refill(now, l.limits.PerMinute, l.limits.Burst)
+		if w := b.wait(l.limits.PerMinute); w > 0 {
+			limited.Inc("client")
+			wait = w
+		}
+		buckets = append(buckets, b)
+	}
+	if l.limits.RepeatPerMinute > 0 {
+		key := repeat{client: client, number: number}
+		b := l.repeats[key]
+		if b == nil {
+			b = &bucket{tokens: float64(l.limits.RepeatBurst), updated: now}
+			l.repeats[key] = b
+		}
+		b.refill(now, l.limits.RepeatPerMinute, l.limits.RepeatBurst)
+		if w := b.wait(l.limits.RepeatPerMinute); w > 0 {
+			limited.Inc("repeat")
+			if w > wait {
+				wait = w
+			}
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// State returns the tokens that are left in every bucket that is not full, the others are as good as new.
+func (l *Limiter) State() responses.RateLimits {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	state := responses.RateLimits{
+		PerMinute:       l.limits.PerMinute,
+		Burst:           l.limits.Burst,
+		RepeatPerMinute: l.limits.RepeatPerMinute,
+		RepeatBurst:     l.limits.RepeatBurst,
+		Clients:         []responses.ClientRateLimit{},
+	}
+	clients := make(map[string]*responses.ClientRateLimit)
+	get := func(client string) *responses.ClientRateLimit {
+		if clients[client] == nil {
+			clients[client] = &responses.ClientRateLimit{Client: client, Tokens: float64(l.limits.Burst), Numbers: []responses.NumberRateLimit{}}
+		}
+		return clients[client]
+	}
+	for client, b := range l.clients {
+		b.refill(now, l.limits.PerMinute, l.limits.Burst)
+		if b.tokens < float64(l.limits.Burst) {
+			get(client).Tokens = b.tokens
+		}
+	}
+	for key, b := range l.repeats {
+		b.refill(now, l.limits.RepeatPerMinute, l.limits.RepeatBurst)
+		if b.tokens < float64(l.limits.RepeatBurst) {
+			c := get(key.client)
+			c.Numbers = append(c.Numbers, responses.NumberRateLimit{Number: json.Number(key.number), Tokens: b.tokens})
+		}
+	}
+	for _, c := range clients {
+		sort.Slice(c.Numbers, func(i, j int) bool { return c.Numbers[i].Number < c.Numbers[j].Number })
+		state.Clients = append(state.Clients, *c)
+	}
+	sort.Slice(state.Clients, func(i, j int) bool { return state.Clients[i].Client < state.Clients[j].Client })
+	return state
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.clients {
+		if b.refill(now, l.limits.PerMinute, l.limits.Burst); b.tokens >= float64(l.limits.Burst) {
+			delete(l.clients, client)
+		}
+	}
+	for key, b := range l.repeats {
+		if b.refill(now, l.limits.RepeatPerMinute, l.limits.RepeatBurst); b.tokens >= float64(l.limits.RepeatBurst) {
+			delete(l.repeats, key)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time, perMinute int, burst int) {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Minutes()*float64(perMinute))
+	b.updated = now
+}
+
+// wait returns how long it takes until the bucket has a token again.
+func (b *bucket) wait(perMinute int) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / float64(perMinute) * float64(time.Minute))
+}
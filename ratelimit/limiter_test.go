@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRepeatsRunOutBeforeTheClientDoes(t *testing.T) {
+	limiter, clock := limiterAt(Limits{PerMinute: 60, Burst: 5, RepeatPerMinute: 6, RepeatBurst: 2})
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow("ip:192.0.2.1", "4"); !allowed {
+			t.Fatalf("Expected request %d for 4 to be allowed", i+1)
+		}
+	}
+	allowed, wait := limiter.Allow("ip:192.0.2.1", "4")
+	if allowed || wait != 10*time.Second {
+		t.Errorf("Expected to wait 10s before asking for 4 again, but got %v and %v", allowed, wait)
+	}
+	if allowed, _ := limiter.Allow("ip:192.0.2.1", "6"); !allowed {
+		t.Error("Expected another number to be allowed")
+	}
+	if allowed, _ := limiter.Allow("ip:192.0.2.2", "4"); !allowed {
+		t.Error("Expected another client to be allowed")
+	}
+
+	*clock = clock.Add(10 * time.Second)
+	if allowed, _ := limiter.Allow("ip:192.0.2.1", "4"); !allowed {
+		t.Error("Expected 4 to be allowed again after waiting")
+	}
+}
+
+func TestClientRunsOut(t *testing.T) {
+	limiter, clock := limiterAt(Limits{PerMinute: 60, Burst: 2})
+
+	for _, number := range []string{"4", "6"} {
+		if allowed, _ := limiter.Allow("client:dashboard", number); !allowed {
+			t.Fatalf("Expected %s to be allowed", number)
+		}
+	}
+	allowed, wait := limiter.Allow("client:dashboard", "8")
+	if allowed || wait != time.Second {
+		t.Errorf("Expected to wait a second, but got %v and %v", allowed, wait)
+	}
+	*clock = clock.Add(500 * time.Millisecond)
+	if _, wait := limiter.Allow("client:dashboard", "8"); wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait another half second, but got %v", wait)
+	}
+}
+
+func TestStateOnlyShowsBucketsInUse(t *testing.T) {
+	limiter, clock := limiterAt(Limits{PerMinute: 60, Burst: 10, RepeatPerMinute: 1, RepeatBurst: 3})
+	limiter.Allow("ip:192.0.2.1", "4")
+	limiter.Allow("ip:192.0.2.1", "4")
+	limiter.Allow("ip:192.0.2.2", "9")
+	*clock = clock.Add(2 * time.Second)
+
+	state := limiter.State()
+	if len(state.Clients) != 2 || state.Clients[0].Client != "ip:192.0.2.1" || state.Clients[0].Tokens != 10 {
+		t.Fatalf("Expected both clients with full buckets, but got %+v", state.Clients)
+	}
+	numbers := state.Clients[0].Numbers
+	if len(numbers) != 1 || numbers[0].Number != "4" || numbers[0].Tokens >= 2 || numbers[0].Tokens <= 1 {
+		t.Errorf("Expected a bit more than one token left for 4, but got %+v", numbers)
+	}
+
+	*clock = clock.Add(5 * time.Minute)
+	limiter.Allow("ip:192.0.2.3", "5")
+	if len(limiter.clients) != 1 || len(limiter.repeats) != 1 {
+		t.Errorf("Expected full buckets to be forgotten, but got %d clients and %d repeats", len(limiter.clients), len(limiter.repeats))
+	}
+}
+
+func limiterAt(limits Limits) (*Limiter, *time.Time) {
+	clock := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := New(limits)
+	limiter.now = func() time.Time { return clock }
+	return limiter, &clock
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"reflect"
+	"time"
+)
+
+var wholeKey = []byte("all")
+
+// BoltRepository stores an entity in its own bbolt database.
+// Maps with string keys are stored one entry per key. Persist only writes the entries that changed,
+// but it has to compare them all, PersistEntries is for when the caller knows which ones changed.
+// Anything else is stored as a single JSON value.
+type BoltRepository struct {
+	db         *bbolt.DB
+	entityName string
+}
+
+func InitializeBolt(folderName string, entityName string) (*BoltRepository, error) {
+	if _, err := Initialize(folderName, entityName); err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(fmt.Sprintf("%s/%s.db", folderName, entityName), 0644, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(entityName))
+		return err
+	})
+	return &BoltRepository{db: db, entityName: entityName}, err
+}
+
+func (p *BoltRepository) Persist(data interface{}) error {
+	err := p.persist(data)
+	if err != nil {
+		persistFailures.Inc(p.entityName)
+	}
+	return err
+}
+
+func (p *BoltRepository) persist(data interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(data))
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(p.entityName))
+		if !isStringMap(value) {
+			bytes, err := json.Marshal(data)
+			if err != nil {
+				return err
+			}
+			return bucket.Put(wholeKey, bytes)
+		}
+		for _, key := range value.MapKeys() {
+			if err := putIfChanged(bucket, []byte(key.String()), value.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+		var removed [][]byte
+		err := bucket.ForEach(func(key, _ []byte) error {
+			if !value.MapIndex(reflect.ValueOf(string(key)).Convert(value.Type().Key())).IsValid() {
+				removed = append(removed, key)
+			}
+			return nil
+		})
+		for _, key := range removed {
+			if err == nil {
+				err = bucket.Delete(key)
+			}
+		}
+		return err
+	})
+}
+
+func (p *BoltRepository) PersistEntries(entries interface{}) error {
+	err := p.persistEntries(entries)
+	if err != nil {
+		persistFailures.Inc(p.entityName)
+	}
+	return err
+}
+
+func (p *BoltRepository) persistEntries(entries interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(entries))
+	if !isStringMap(value) {
+		return fmt.Errorf("can only persist the entries of a map with string keys, not of %T", entries)
+	}
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(p.entityName))
+		for _, key := range value.MapKeys() {
+			entryBytes, err := json.Marshal(value.MapIndex(key).Interface())
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(key.String()), entryBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltRepository) ReadAll(data interface{}) error {
+	target := reflect.ValueOf(data)
+	if target.Kind() != reflect.Ptr {
+		return fmt.Errorf("can only read into a pointer, not into %T", data)
+	}
+	value := target.Elem()
+	return p.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(p.entityName))
+		if !isStringMap(value) {
+			if bytes := bucket.Get(wholeKey); bytes != nil {
+				return json.Unmarshal(bytes, data)
+			}
+			return nil
+		}
+		if bucket.Stats().KeyN == 0 {
+			return nil
+		}
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
+		return bucket.ForEach(func(key, bytes []byte) error {
+			entry := reflect.New(value.Type().Elem())
+			if err := json.Unmarshal(bytes, entry.Interface()); err != nil {
+				return err
+			}
+			value.SetMapIndex(reflect.ValueOf(string(key)).Convert(value.Type().Key()), entry.Elem())
+			return nil
+		})
+	})
+}
+
+func (p *BoltRepository) Close() error {
+	return p.db.Close()
+}
+
+func isStringMap(value reflect.Value) bool {
+	return value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String
+}
+
+func putIfChanged(bucket *bbolt.Bucket, key []byte, entry interface{}) error {
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(bucket.Get(key), entryBytes) {
+		return nil
+	}
+	return bucket.Put(key, entryBytes)
+}
@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"tbp.com/user/hello/logging"
 )
 
 type FileRepository struct {
@@ -28,12 +28,59 @@ func (p FileRepository) getFileName() string {
 	return fmt.Sprintf("%s/%s.json", p.folderName, p.entityName)
 }
 
+// Persist writes to a temporary file first and renames it once it is safely on disk,
+// so a crash halfway never leaves a truncated file behind.
 func (p FileRepository) Persist(data interface{}) error {
-	bytes, _ := json.Marshal(data)
-	err := ioutil.WriteFile(p.getFileName(), bytes, 0644)
+	err := p.persist(data)
+	if err != nil {
+		persistFailures.Inc(p.entityName)
+	}
 	return err
 }
 
+func (p FileRepository) persist(data interface{}) error {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	temporaryFile, err := ioutil.TempFile(p.folderName, p.entityName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+	_, err = temporaryFile.Write(bytes)
+	if err == nil {
+		err = temporaryFile.Chmod(0644)
+	}
+	if err == nil {
+		err = temporaryFile.Sync()
+	}
+	if closeErr := temporaryFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temporaryFile.Name(), p.getFileName())
+	if err != nil {
+		return err
+	}
+	return syncFolder(p.folderName)
+}
+
+func syncFolder(folderName string) error {
+	folder, err := os.Open(folderName)
+	if err != nil {
+		return err
+	}
+	defer folder.Close()
+	return folder.Sync()
+}
+
+func (p FileRepository) Close() error {
+	return nil
+}
+
 func (p FileRepository) ReadAll(data interface{}) error {
 	fileName := p.getFileName()
 	_, err := os.Stat(fileName)
@@ -45,7 +92,7 @@ func (p FileRepository) ReadAll(data interface{}) error {
 		defer jsonFile.Close()
 		err = json.NewDecoder(jsonFile).Decode(data)
 		if err == io.EOF {
-			log.Println(err)
+			logging.Warn("Read an empty file", "component", "repository", "file", fileName)
 			// The file exists, but is empty, for some reason.
 			// Logging it to be on the safe side, so it can help to analyse if this happens a lot
 			return nil
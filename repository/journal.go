@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tbp.com/user/hello/logging"
+)
+
+// Journal is an append-only log of changes to an entity, replayed on top of its last persisted state.
+// Changes are only guaranteed to survive a crash after Sync returned.
+// StartSegment appends later changes to a new segment, so the state up to then can be persisted while changes keep coming in.
+// DropOlderSegments then removes what that state already holds, Truncate removes all of it.
+type Journal interface {
+	Append(change interface{}) error
+	Sync() error
+	Replay(apply func(decode func(change interface{}) error) error) error
+	StartSegment() error
+	DropOlderSegments() error
+	Truncate() error
+	Close() error
+}
+
+func OpenJournal(kind string, folderName string, entityName string) (Journal, error) {
+	if kind == Memory {
+		return InitializeMemoryJournal(), nil
+	}
+	return InitializeFileJournal(folderName, entityName)
+}
+
+// FileJournal keeps one JSON document per line. Concurrent calls to Sync are coalesced,
+// so a single sync to disk covers everything appended until then.
+// Changes are appended to e.g. history.log, older segments are renamed to history.log.1, history.log.2 and so on.
+type FileJournal struct {
+	mutex     sync.Mutex
+	syncMutex sync.Mutex
+	path      string
+	file      *os.File
+	appended  int
+	synced    int
+	segment   int
+}
+
+func InitializeFileJournal(folderName string, entityName string) (*FileJournal, error) {
+	if _, err := Initialize(folderName, entityName); err != nil {
+		return nil, err
+	}
+	j := &FileJournal{path: fmt.Sprintf("%s/%s.log", folderName, entityName)}
+	segments, err := j.olderSegments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		j.segment = segments[len(segments)-1]
+	}
+	j.file, err = os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// olderSegments returns the numbers of the older segments, oldest first.
+func (j *FileJournal) olderSegments() ([]int, error) {
+	paths, err := filepath.Glob(j.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, path := range paths {
+		if segment, err := strconv.Atoi(strings.TrimPrefix(path, j.path+".")); err == nil {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (j *FileJournal) segmentPath(segment int) string {
+	return fmt.Sprintf("%s.%d", j.path, segment)
+}
+
+func (j *FileJournal) Append(change interface{}) error {
+	line, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	_, err = j.file.Write(append(line, '\n'))
+	j.appended++
+	return err
+}
+
+func (j *FileJournal) Sync() error {
+	j.mutex.Lock()
+	appended := j.appended
+	j.mutex.Unlock()
+
+	j.syncMutex.Lock()
+	defer j.syncMutex.Unlock()
+	if j.synced >= appended {
+		return nil
+	}
+	j.mutex.Lock()
+	appended = j.appended
+	j.mutex.Unlock()
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.synced = appended
+	return nil
+}
+
+func (j *FileJournal) Replay(apply func(decode func(change interface{}) error) error) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	segments, err := j.olderSegments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		content, err := ioutil.ReadFile(j.segmentPath(segment))
+		if err != nil {
+			return err
+		}
+		// Only the current segment can end in a partial line, it is dropped below before a new segment is started.
+		if err := replayLines(content[:bytes.LastIndexByte(content, '\n')+1], apply); err != nil {
+			return err
+		}
+	}
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(j.file)
+	if err != nil {
+		return err
+	}
+	complete := bytes.LastIndexByte(content, '\n') + 1
+	if complete < len(content) {
+		// A crash while appending leaves a partial last line, the change it held never got acknowledged.
+		logging.Warn("Dropping incomplete change", "component", "repository", "change", string(content[complete:]), "file", j.file.Name())
+		if err := j.file.Truncate(int64(complete)); err != nil {
+			return err
+		}
+	}
+	return replayLines(content[:complete], apply)
+}
+
+// StartSegment syncs the current segment and renames it, so it survives a crash until it is dropped.
+// It is left alone when nothing was appended to it.
+func (j *FileJournal) StartSegment() error {
+	j.syncMutex.Lock()
+	defer j.syncMutex.Unlock()
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	info, err := j.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.synced = j.appended
+	if err := os.Rename(j.path, j.segmentPath(j.segment+1)); err != nil {
+		return err
+	}
+	j.segment++
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+func (j *FileJournal) DropOlderSegments() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.dropOlderSegments()
+}
+
+func (j *FileJournal) dropOlderSegments() error {
+	segments, err := j.olderSegments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err := os.Remove(j.segmentPath(segment)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *FileJournal) Truncate() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := j.dropOlderSegments(); err != nil {
+		return err
+	}
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *FileJournal) Close() error {
+	return j.file.Close()
+}
+
+type MemoryJournal struct {
+	mutex sync.Mutex
+	older []byte
+	lines []byte
+}
+
+func InitializeMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{}
+}
+
+func (j *MemoryJournal) Append(change interface{}) error {
+	line, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.lines = append(append(j.lines, line...), '\n')
+	return nil
+}
+
+func (j *MemoryJournal) Sync() error {
+	return nil
+}
+
+func (j *MemoryJournal) Replay(apply func(decode func(change interface{}) error) error) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := replayLines(j.older, apply); err != nil {
+		return err
+	}
+	return replayLines(j.lines, apply)
+}
+
+func (j *MemoryJournal) StartSegment() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.older = append(j.older, j.lines...)
+	j.lines = nil
+	return nil
+}
+
+func (j *MemoryJournal) DropOlderSegments() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.older = nil
+	return nil
+}
+
+func (j *MemoryJournal) Truncate() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.older, j.lines = nil, nil
+	return nil
+}
+
+func (j *MemoryJournal) Close() error {
+	return nil
+}
+
+func replayLines(lines []byte, apply func(decode func(change interface{}) error) error) error {
+	for _, line := range bytes.Split(lines, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		err := apply(func(change interface{}) error {
+			return json.Unmarshal(line, change)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type increment struct {
+	Number string
+}
+
+func TestJournalReplaysAppendedChanges(t *testing.T) {
+	folder := t.TempDir()
+	journal, err := InitializeFileJournal(folder, "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []string{"4", "6"} {
+		if err := journal.Append(increment{Number: number}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := journal.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	journal, err = InitializeFileJournal(folder, "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if actual := replayNumbers(t, journal); len(actual) != 2 || actual[0] != "4" || actual[1] != "6" {
+		t.Errorf("Expected [4 6], but got %v", actual)
+	}
+
+	if err := journal.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if actual := replayNumbers(t, journal); len(actual) != 0 {
+		t.Errorf("Expected nothing after truncating, but got %v", actual)
+	}
+}
+
+func TestJournalDropsOlderSegments(t *testing.T) {
+	folder := t.TempDir()
+	journal, err := InitializeFileJournal(folder, "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []string{"4", "6"} {
+		if err := journal.Append(increment{Number: number}); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.StartSegment(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := journal.Append(increment{Number: "8"}); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	journal, err = InitializeFileJournal(folder, "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if actual := replayNumbers(t, journal); len(actual) != 3 || actual[0] != "4" || actual[1] != "6" || actual[2] != "8" {
+		t.Errorf("Expected [4 6 8] from all segments, but got %v", actual)
+	}
+	if err := journal.StartSegment(); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Append(increment{Number: "10"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.DropOlderSegments(); err != nil {
+		t.Fatal(err)
+	}
+	if actual := replayNumbers(t, journal); len(actual) != 1 || actual[0] != "10" {
+		t.Errorf("Expected only [10] of the current segment, but got %v", actual)
+	}
+}
+
+func TestJournalDropsIncompleteLastChange(t *testing.T) {
+	folder := t.TempDir()
+	err := ioutil.WriteFile(folder+"/entries.log", []byte("{\"Number\":\"4\"}\n{\"Numb"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal, err := InitializeFileJournal(folder, "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	if actual := replayNumbers(t, journal); len(actual) != 1 || actual[0] != "4" {
+		t.Errorf("Expected [4], but got %v", actual)
+	}
+	if err := journal.Append(increment{Number: "6"}); err != nil {
+		t.Fatal(err)
+	}
+	if actual := replayNumbers(t, journal); len(actual) != 2 || actual[1] != "6" {
+		t.Errorf("Expected [4 6], but got %v", actual)
+	}
+}
+
+func TestFilePersistLeavesNoTemporaryFiles(t *testing.T) {
+	folder := t.TempDir()
+	repository, err := Initialize(folder, "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for count := 0; count < 3; count++ {
+		if err := repository.Persist(map[string]int{"4": count}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "entries.json" || files[0].Mode() != os.FileMode(0644) {
+		t.Errorf("Expected only entries.json with mode 0644, but got %d files, starting with %s %s", len(files), files[0].Name(), files[0].Mode())
+	}
+}
+
+func replayNumbers(t *testing.T, journal Journal) []string {
+	var numbers []string
+	err := journal.Replay(func(decode func(interface{}) error) error {
+		var i increment
+		err := decode(&i)
+		numbers = append(numbers, i.Number)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return numbers
+}
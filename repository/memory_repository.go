@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// MemoryRepository keeps the data as JSON, so what is read back is a copy, just like from the other repositories.
+type MemoryRepository struct {
+	mutex sync.Mutex
+	bytes []byte
+}
+
+func InitializeMemory() *MemoryRepository {
+	return &MemoryRepository{}
+}
+
+func (p *MemoryRepository) Persist(data interface{}) error {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.bytes = bytes
+	return nil
+}
+
+func (p *MemoryRepository) ReadAll(data interface{}) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.bytes == nil {
+		return nil
+	}
+	return json.Unmarshal(p.bytes, data)
+}
+
+func (p *MemoryRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,5 @@
+package repository
+
+import "tbp.com/user/hello/metrics"
+
+var persistFailures = metrics.NewCounter("hello_persist_failures_total", "Failures to persist an entity.", "entity")
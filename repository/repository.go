@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	File   = "file"
+	Memory = "memory"
+	Bolt   = "bolt"
+)
+
+// SchemaVersion is the version of how entities are stored. It goes up whenever stored data has to be migrated on startup.
+// Version 2 added the kind of feedback messages.
+const SchemaVersion = 2
+
+// Repository stores all data of one entity, like the history or the feedback messages.
+type Repository interface {
+	Persist(data interface{}) error
+	ReadAll(data interface{}) error
+	Close() error
+}
+
+// EntryRepository stores every entry of a map with string keys on its own. PersistEntries only writes the entries it is given
+// and leaves the others as they are, so persisting a few changes doesn't cost as much as persisting all entries.
+type EntryRepository interface {
+	Repository
+	PersistEntries(entries interface{}) error
+}
+
+// Open initializes the kind of repository that was chosen at startup.
+func Open(kind string, folderName string, entityName string) (Repository, error) {
+	switch kind {
+	case File:
+		return Initialize(folderName, entityName)
+	case Memory:
+		return InitializeMemory(), nil
+	case Bolt:
+		return InitializeBolt(folderName, entityName)
+	}
+	return nil, fmt.Errorf("unknown kind of repository %q, must be one of %q, %q or %q", kind, File, Memory, Bolt)
+}
+
+// Writable checks that files can be created in the folder, by creating and removing one.
+func Writable(folderName string) error {
+	file, err := ioutil.TempFile(folderName, ".writable-*")
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"testing"
+)
+
+type entry struct {
+	Count int
+}
+
+func TestRepositoriesRoundTrip(t *testing.T) {
+	for _, kind := range []string{File, Memory, Bolt} {
+		t.Run("Reads back what was persisted in "+kind, func(t *testing.T) {
+			repository, err := Open(kind, t.TempDir(), "entries")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repository.Close()
+
+			var empty map[string]*entry
+			if err := repository.ReadAll(&empty); err != nil || empty != nil {
+				t.Fatalf("Expected nothing to be read from an empty repository, but got %+v, %v", empty, err)
+			}
+
+			persisted := map[string]*entry{"4": {Count: 1}, "6": {Count: 2}}
+			if err := repository.Persist(persisted); err != nil {
+				t.Fatal(err)
+			}
+			delete(persisted, "4")
+			persisted["6"].Count = 3
+			persisted["97"] = &entry{Count: 100}
+			if err := repository.Persist(persisted); err != nil {
+				t.Fatal(err)
+			}
+
+			var actual map[string]*entry
+			if err := repository.ReadAll(&actual); err != nil {
+				t.Fatal(err)
+			}
+			if len(actual) != 2 || actual["6"].Count != 3 || actual["97"].Count != 100 {
+				t.Errorf("Expected %+v, but got %+v", persisted, actual)
+			}
+		})
+	}
+}
+
+func TestBoltPersistsOnlyTheGivenEntries(t *testing.T) {
+	repository, err := InitializeBolt(t.TempDir(), "entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+	if err := repository.Persist(map[string]*entry{"4": {Count: 1}, "6": {Count: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.PersistEntries(map[string]*entry{"6": {Count: 3}, "97": {Count: 100}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual map[string]*entry
+	if err := repository.ReadAll(&actual); err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != 3 || actual["4"].Count != 1 || actual["6"].Count != 3 || actual["97"].Count != 100 {
+		t.Errorf("Expected 4 to be left alone, and 6 and 97 to be written, but got %+v", actual)
+	}
+}
+
+func TestRepositoriesStoreWholeValues(t *testing.T) {
+	for _, kind := range []string{File, Memory, Bolt} {
+		t.Run("Reads back a whole value persisted in "+kind, func(t *testing.T) {
+			repository, err := Open(kind, t.TempDir(), "entry")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repository.Close()
+
+			if err := repository.Persist(entry{Count: 9001}); err != nil {
+				t.Fatal(err)
+			}
+			var actual entry
+			if err := repository.ReadAll(&actual); err != nil {
+				t.Fatal(err)
+			}
+			if actual.Count != 9001 {
+				t.Errorf("Expected count 9001, but got %d", actual.Count)
+			}
+		})
+	}
+}
+
+func TestOpenRejectsUnknownKinds(t *testing.T) {
+	if _, err := Open("tape", t.TempDir(), "entries"); err == nil {
+		t.Error("Expected an error")
+	}
+}
@@ -1,30 +1,124 @@
 package responses
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Primes struct {
-	IsPrime bool   `json:"isPrime"`
-	Message string `json:"message"`
+	IsPrime        bool        `json:"isPrime"`
+	Message        string      `json:"message"`
+	Verdict        string      `json:"verdict"`
+	Rounds         int         `json:"rounds,omitempty"`
+	Certainty      float64     `json:"certainty"`
+	SmallestFactor json.Number `json:"smallestFactor,omitempty"`
+}
+
+// BatchPrimes answers for a number in a batch, like /primes/{number} would. Or why it can't, in Error.
+type BatchPrimes struct {
+	Number json.Number `json:"number"`
+	*Primes
+	Error string `json:"error,omitempty"`
+}
+
+type Batch struct {
+	Results []BatchPrimes `json:"results"`
+}
+
+type Factor struct {
+	Prime    json.Number `json:"prime"`
+	Exponent int         `json:"exponent"`
+}
+
+type Factors struct {
+	Number    json.Number `json:"number"`
+	Factors   []Factor    `json:"factors"`
+	Complete  bool        `json:"complete"`
+	Remainder json.Number `json:"remainder,omitempty"`
 }
 
 type Request struct {
-	Number int `json:"number"`
-	Count  int `json:"count"`
+	Number    json.Number `json:"number"`
+	Count     int         `json:"count"`
+	IsPrime   bool        `json:"isPrime"`
+	FirstSeen *time.Time  `json:"firstSeen,omitempty"`
+	LastSeen  *time.Time  `json:"lastSeen,omitempty"`
+}
+
+type Totals struct {
+	DistinctNumbers  int `json:"distinctNumbers"`
+	TotalRequests    int `json:"totalRequests"`
+	PrimeNumbers     int `json:"primeNumbers"`
+	NonPrimeNumbers  int `json:"nonPrimeNumbers"`
+	PrimeRequests    int `json:"primeRequests"`
+	NonPrimeRequests int `json:"nonPrimeRequests"`
 }
 
 type History struct {
 	Requests []Request `json:"requests"`
+	Totals   Totals    `json:"totals"`
 }
 
 type Message struct {
 	LowerLimit int    `json:"lowerLimit"`
 	Message    string `json:"message"`
+	Kind       string `json:"kind"`
 }
 
 type MessageSlice []Message
 
-func (ms MessageSlice) Len() int           { return len(ms) }
-func (ms MessageSlice) Less(i, j int) bool { return ms[i].LowerLimit > ms[j].LowerLimit }
-func (ms MessageSlice) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+func (ms MessageSlice) Len() int { return len(ms) }
+func (ms MessageSlice) Less(i, j int) bool {
+	if ms[i].Kind != ms[j].Kind {
+		return ms[i].Kind < ms[j].Kind
+	}
+	return ms[i].LowerLimit > ms[j].LowerLimit
+}
+func (ms MessageSlice) Swap(i, j int) { ms[i], ms[j] = ms[j], ms[i] }
 
 type Messages struct {
 	Messages MessageSlice `json:"messages"`
 }
+
+type PrimeRange struct {
+	From   int   `json:"from"`
+	To     int   `json:"to"`
+	Primes []int `json:"primes"`
+	Next   *int  `json:"next,omitempty"`
+}
+
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Ready  bool    `json:"ready"`
+	Checks []Check `json:"checks"`
+}
+
+type Version struct {
+	Version       string `json:"version"`
+	GoVersion     string `json:"goVersion"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+type NumberRateLimit struct {
+	Number json.Number `json:"number"`
+	Tokens float64     `json:"tokens"`
+}
+
+type ClientRateLimit struct {
+	Client  string            `json:"client"`
+	Tokens  float64           `json:"tokens"`
+	Numbers []NumberRateLimit `json:"numbers"`
+}
+
+type RateLimits struct {
+	PerMinute       int               `json:"perMinute"`
+	Burst           int               `json:"burst"`
+	RepeatPerMinute int               `json:"repeatPerMinute"`
+	RepeatBurst     int               `json:"repeatBurst"`
+	Clients         []ClientRateLimit `json:"clients"`
+}
@@ -1,120 +1,701 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/gorilla/handlers"
+	"github.com/felixge/httpsnoop"
 	"github.com/gorilla/mux"
 	"io"
 	"io/ioutil"
-	"log"
+	"math"
+	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"tbp.com/user/hello/auth"
+	"tbp.com/user/hello/config"
 	"tbp.com/user/hello/history"
+	"tbp.com/user/hello/logging"
 	"tbp.com/user/hello/messages"
+	"tbp.com/user/hello/metrics"
+	"tbp.com/user/hello/primes"
+	"tbp.com/user/hello/ratelimit"
+	"tbp.com/user/hello/repository"
 	"tbp.com/user/hello/responses"
+	"tbp.com/user/hello/ui"
+	"time"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	maxDigits       = 1000
+	// batchOverhead is what a number in a batch may take on top of its digits: quotes, separators and whitespace
+	batchOverhead   = 24
+	shutdownTimeout = 10 * time.Second
+
+	ndjsonContentType = "application/x-ndjson"
 )
 
 func main() {
-	ensureLogsDirectory()
-	serverLog := createServerLogFile()
+	if err := serve(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// serve returns the error the server failed with, once everything it opened is closed and flushed.
+func serve() error {
+	configuration, err := config.Load(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+	ensureLogsDirectory(configuration.ServerLog, configuration.HttpLog)
+	serverLog := createServerLogFile(configuration)
 	defer close(serverLog)
-	log.SetOutput(io.MultiWriter(os.Stdout, serverLog))
-	memories, err := history.Setup("data")
+	logging.SetDefault(logging.New(io.MultiWriter(os.Stdout, serverLog)))
+	logging.Info("Starting", "configuration", configuration)
+	historyRepository := openRepository(configuration, "history")
+	defer historyRepository.Close()
+	historyJournal, err := repository.OpenJournal(configuration.Storage, configuration.DataFolder, "history")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Can't open journal of history", err)
 	}
-	feedbackMessages, err := messages.Setup("data")
+	defer historyJournal.Close()
+	memories, err := history.Setup(historyRepository, historyJournal)
+	if err != nil {
+		logging.Fatal("Can't load history", err)
+	}
+	memories.Retain(history.Retention{
+		MaxAge:     time.Duration(configuration.HistoryMaxAge),
+		MaxNumbers: configuration.HistoryMaxNumbers,
+		HalfLife:   time.Duration(configuration.HistoryHalfLife),
+		Interval:   time.Duration(configuration.HistoryRetentionInterval),
+	})
+	messagesRepository := openRepository(configuration, "messages")
+	defer messagesRepository.Close()
+	feedbackMessages, err := messages.Setup(messagesRepository)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Can't load feedback messages", err)
 	}
-	logWriter, logFile := setupHttpLogWriter()
+	logWriter, logFile := setupHttpLogWriter(configuration)
 	defer close(logFile)
+	go reopenOnHangup(serverLog, logFile)
+
+	authorization, err := auth.Setup(credentialsRepository(configuration.DataFolder))
+	if err != nil {
+		logging.Fatal("Can't load credentials", err)
+	}
+
+	server := &http.Server{
+		Addr:     configuration.Address,
+		Handler:  requestLogging(logging.New(logWriter), setupRouter(configuration, memories, feedbackMessages, authorization)),
+		ErrorLog: logging.Default().StandardLogger(logging.LevelError),
+	}
+	ctx, cancel, err := serveUntilSignalled(server)
+	defer cancel()
+	closeWithin(ctx, "history", memories.Close)
+	closeWithin(ctx, "feedback messages", feedbackMessages.Close)
+	logging.Info("Stopped")
+	return err
+}
+
+// serveUntilSignalled returns once the server stopped, after SIGINT or SIGTERM, or because it failed to start.
+// In-flight requests are drained before it returns. The returned context holds the deadline for the rest of the shutdown,
+// the error is why the server failed, or nil when it was signalled.
+func serveUntilSignalled(server *http.Server) (context.Context, context.CancelFunc, error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	var err error
+	select {
+	case received := <-signals:
+		logging.Info("Shutting down", "signal", received)
+	case err = <-failed:
+		logging.Error("Server stopped", "error", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := server.Shutdown(ctx); err != nil {
+		logging.Error("Failed to shut down", "error", err)
+	}
+	return ctx, cancel, err
+}
+
+func closeWithin(ctx context.Context, name string, closer func() error) {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- closer()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			logging.Error("Failed to close", "name", name, "error", err)
+		}
+	case <-ctx.Done():
+		logging.Error("Gave up waiting to close", "name", name, "error", ctx.Err())
+	}
+}
 
-	http.ListenAndServe(":8080", handlers.LoggingHandler(logWriter, setupRouter(memories, feedbackMessages)))
+func openRepository(configuration config.Config, entityName string) repository.Repository {
+	entityRepository, err := repository.Open(configuration.Storage, configuration.DataFolder, entityName)
+	if err != nil {
+		logging.Fatal("Can't open repository of "+entityName, err)
+	}
+	return entityRepository
 }
 
-func ensureLogsDirectory() {
-	err := os.MkdirAll("logs", 0755)
+// Credentials are always read from a JSON file, whatever storage was chosen, so they can be edited by hand.
+func credentialsRepository(dataFolder string) repository.Repository {
+	credentials, err := repository.Initialize(dataFolder, "credentials")
 	if err != nil {
-		panic(err)
+		logging.Fatal("Can't open credentials", err)
 	}
+	return credentials
 }
 
-func createServerLogFile() *os.File {
-	serverLog, err := os.OpenFile("logs/server.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm)
+func ensureLogsDirectory(logFiles ...string) {
+	for _, logFile := range logFiles {
+		err := os.MkdirAll(filepath.Dir(logFile), 0755)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func createServerLogFile(configuration config.Config) *logging.RotatingFile {
+	serverLog, err := openLogFile(configuration, configuration.ServerLog)
 	if err != nil {
 		panic(err)
 	}
 	return serverLog
 }
 
-func close(logFile *os.File) {
+func close(logFile *logging.RotatingFile) {
 	err := logFile.Close()
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Can't close log file", err)
 	}
 }
 
-func setupHttpLogWriter() (io.Writer, *os.File) {
-	logFile, err := os.OpenFile("logs/http.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm)
+func setupHttpLogWriter(configuration config.Config) (io.Writer, *logging.RotatingFile) {
+	logFile, err := openLogFile(configuration, configuration.HttpLog)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Can't open HTTP log", err)
 	}
 	return io.MultiWriter(os.Stdout, logFile), logFile
 }
 
-func setupRouter(memories history.Service, feedbackMessages *messages.Service) *mux.Router {
+func openLogFile(configuration config.Config, fileName string) (*logging.RotatingFile, error) {
+	maxSize := int64(configuration.LogMaxSize) * 1024 * 1024
+	return logging.OpenRotatingFile(fileName, maxSize, time.Duration(configuration.LogRotateInterval), configuration.LogRetain)
+}
+
+// reopenOnHangup reopens the log files on SIGHUP, for when they were moved by something else than the service itself.
+func reopenOnHangup(logFiles ...*logging.RotatingFile) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		for _, logFile := range logFiles {
+			if err := logFile.Reopen(); err != nil {
+				logging.Error("Failed to reopen log file", "error", err)
+			}
+		}
+		logging.Info("Reopened log files")
+	}
+}
+
+func setupRouter(configuration config.Config, memories *history.Service, feedbackMessages *messages.Service, authorization *auth.Service) *mux.Router {
 	r := mux.NewRouter()
 	r.StrictSlash(true)
 	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("No can do"))
 	})
+	r.Use(metricsMiddleware)
+	limiter := ratelimit.New(ratelimit.Limits{
+		PerMinute:       configuration.RateLimit,
+		Burst:           configuration.RateLimitBurst,
+		RepeatPerMinute: configuration.RepeatRateLimit,
+		RepeatBurst:     configuration.RepeatRateLimitBurst,
+	})
+	identify := func(r *http.Request) string {
+		return authorization.Identify(r, configuration.ClientHeader)
+	}
+	// Anyone can send the client header, so it only attributes requests and plays no part in rate limiting
+	rateLimitIdentify := func(r *http.Request) string {
+		return authorization.Identify(r, "")
+	}
 	r.HandleFunc("/", homeHandler).Methods(http.MethodGet)
+	r.HandleFunc("/metrics", metrics.DefaultRegistry.Handler()).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", healthzHandler).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", readyzHandler(configuration, memories, feedbackMessages)).Methods(http.MethodGet)
+	r.HandleFunc("/version", versionHandler).Methods(http.MethodGet)
+	frontend := ui.Handler("/ui")
+	// Strict slashes redirect /ui to /ui/, but not for a path prefix
+	r.Handle("/ui/", frontend).Methods(http.MethodGet)
+	r.PathPrefix("/ui/").Handler(frontend).Methods(http.MethodGet)
 	r.HandleFunc("/history", historyHandler(memories)).Methods(http.MethodGet)
-	r.HandleFunc("/primes/{number:[0-9]+}", primeHandler(memories, feedbackMessages)).Methods(http.MethodGet)
+	r.HandleFunc("/history", authorization.Require(auth.Admin, historyDELETEHandler(memories))).Methods(http.MethodDelete)
+	r.HandleFunc("/primes", primeRangeHandler).Queries("from", "{from}", "to", "{to}").Methods(http.MethodGet)
+	r.HandleFunc("/primes/{number:[0-9]+}", rateLimited(limiter, rateLimitIdentify, configuration.RateLimitMessage, primeHandler(memories, feedbackMessages, identify, configuration.MessageCount, time.Duration(configuration.MessageWindow)))).Methods(http.MethodGet)
+	// A batch takes a reader rather than being rate limited, as charging a token per number would only allow tiny batches
+	// The body is limited before authenticating, as verifying a signature reads all of it
+	maxBatchBytes := int64(configuration.BatchLimit) * (maxDigits + batchOverhead)
+	r.HandleFunc("/primes/batch", limitBody(maxBatchBytes, authorization.Require(auth.Reader, batchHandler(memories, feedbackMessages, identify, configuration)))).Methods(http.MethodPost)
+	r.HandleFunc("/primes/{number:[0-9]+}/factors", rateLimited(limiter, rateLimitIdentify, configuration.RateLimitMessage, factorsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/ratelimits", authorization.Require(auth.Admin, rateLimitsHandler(limiter))).Methods(http.MethodGet)
 	r.HandleFunc("/messages", feedbackMessagesGETHandler(feedbackMessages)).Methods(http.MethodGet)
-	r.HandleFunc("/messages", feedbackMessagesPOSTHandler(feedbackMessages)).Methods(http.MethodPost)
+	r.HandleFunc("/messages", authorization.Require(auth.Editor, feedbackMessagesPOSTHandler(feedbackMessages))).Methods(http.MethodPost)
 	return r
 }
 
+var (
+	httpRequests        = metrics.NewCounter("hello_http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code")
+	httpRequestDuration = metrics.NewHistogram("hello_http_request_duration_seconds", "Time it took to answer HTTP requests by route and method.", metrics.DefaultBuckets, "route", "method")
+)
+
+// requestLogging gives every request an ID, or takes the one from X-Request-ID, and returns it in the same header.
+// Everything logged for the request, including the line in the access log, mentions the ID.
+func requestLogging(accessLog *logging.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := logging.RequestID(r.Header.Get(logging.RequestIDHeader))
+		w.Header().Set(logging.RequestIDHeader, requestID)
+		r = r.WithContext(logging.WithLogger(r.Context(), logging.Default().With("requestId", requestID)))
+		captured := httpsnoop.CaptureMetrics(next, w, r)
+		accessLog.Info("Handled request", "requestId", requestID, "remoteAddr", r.RemoteAddr, "method", r.Method,
+			"uri", r.RequestURI, "status", captured.Code, "bytes", captured.Written, "durationSeconds", captured.Duration.Seconds())
+	})
+}
+
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil {
+			route = "unknown"
+		}
+		captured := httpsnoop.CaptureMetrics(next, w, r)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(captured.Code))
+		httpRequestDuration.Observe(captured.Duration.Seconds(), route, r.Method)
+	})
+}
+
 func homeHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Home!")
 }
 
-func primeHandler(memories history.Service, feedbackMessages *messages.Service) func(w http.ResponseWriter, r *http.Request) {
+// healthzHandler only tells the process is alive, so it is restarted when it stops answering.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK")
+}
+
+// readyzHandler tells whether requests can be handled and their history kept. It answers 503 when they can't.
+// The repositories are loaded before the server starts listening, so they don't need to be checked here.
+func readyzHandler(configuration config.Config, memories *history.Service, feedbackMessages *messages.Service) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		potentialNumber := vars["number"]
-		number, err := strconv.Atoi(potentialNumber)
+		readiness := responses.Readiness{Ready: true}
+		for _, check := range []struct {
+			name string
+			err  error
+		}{
+			{"dataFolder", repository.Writable(configuration.DataFolder)},
+			{"historyPersisted", memories.LastPersistError()},
+			{"messagesPersisted", feedbackMessages.LastPersistError()},
+		} {
+			result := responses.Check{Name: check.name, OK: check.err == nil}
+			if check.err != nil {
+				result.Error = check.err.Error()
+				readiness.Ready = false
+			}
+			readiness.Checks = append(readiness.Checks, result)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !readiness.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(readiness); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to write response", "error", err)
+		}
+	}
+}
+
+// version is set when building, with -ldflags "-X main.version=1.2.3".
+var version string
+
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	sendAsJSONResponse(w, responses.Version{Version: buildVersion(), GoVersion: runtime.Version(), SchemaVersion: repository.SchemaVersion})
+}
+
+// limitBody answers 413 when the body is announced to be larger than limit, and fails reading a body that turns out to be.
+func limitBody(limit int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			http.Error(w, fmt.Sprintf("A body can't be larger than %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
+
+// rateLimited answers 429 with the message when the client asked too often, or asked for the same number too often.
+func rateLimited(limiter *ratelimit.Limiter, identify func(*http.Request) string, message string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		number := strings.TrimLeft(mux.Vars(r)["number"], "0")
+		if number == "" {
+			number = "0"
+		}
+		client := identify(r)
+		if allowed, wait := limiter.Allow(client, number); !allowed {
+			logging.FromContext(r.Context()).Warn("Rate limited", "client", client, "number", number, "wait", wait)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, message, http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func rateLimitsHandler(limiter *ratelimit.Limiter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendAsJSONResponse(w, limiter.State())
+	}
+}
+
+// primeHandler counts the request both for everyone and for the client. messageCount decides which count picks the feedback message,
+// a window only counts the requests within that time before now.
+func primeHandler(memories *history.Service, feedbackMessages *messages.Service, identify func(*http.Request) string, messageCount string, window time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		number, ok := numberFromPath(w, r)
+		if !ok {
+			return
+		}
+		client := identify(r)
+		memories.Update(r.Context(), number, client)
+		sendAsJSONResponse(w, memories.ToPrimeResponse(r.Context(), number, countingFor(client, messageCount, window), feedbackMessages))
+	}
+}
+
+// countingFor selects the requests that pick the feedback message for the client.
+func countingFor(client string, messageCount string, window time.Duration) history.Counting {
+	var counting history.Counting
+	if messageCount == config.CountClient {
+		counting.Client = client
+	}
+	if window > 0 {
+		counting.Since = time.Now().Add(-window)
+	}
+	return counting
+}
+
+// batchHandler answers for every number in a JSON array, or in a stream of them with Content-Type application/x-ndjson,
+// which is answered in kind. The numbers are checked in parallel, and only counted in the history when configured to.
+func batchHandler(memories *history.Service, feedbackMessages *messages.Service, identify func(*http.Request) string, configuration config.Config) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A signature covers the body, so the client is identified before the body is read
+		client := identify(r)
+		ndjson := strings.HasPrefix(r.Header.Get("Content-Type"), ndjsonContentType)
+		numbers, err := readBatch(r.Body, ndjson, configuration.BatchLimit)
+		if err == errBatchTooLarge {
+			http.Error(w, fmt.Sprintf("A batch can't hold more than %d numbers", configuration.BatchLimit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Not a list of numbers: %v", err), http.StatusBadRequest)
+			return
+		}
+		counting := countingFor(client, configuration.MessageCount, time.Duration(configuration.MessageWindow))
+		results := make([]responses.BatchPrimes, len(numbers))
+		check := func(i int) {
+			results[i].Number = numbers[i]
+			if len(numbers[i]) > maxDigits {
+				results[i].Error = fmt.Sprintf("Can't handle more than %d digits", maxDigits)
+				return
+			}
+			number, ok := new(big.Int).SetString(numbers[i].String(), 10)
+			if !ok || number.Sign() < 0 {
+				results[i].Error = fmt.Sprintf("Not a positive integer: %s", numbers[i])
+				return
+			}
+			var primes responses.Primes
+			if configuration.BatchCountsHistory {
+				memories.Update(r.Context(), number, client)
+				primes = memories.ToPrimeResponse(r.Context(), number, counting, feedbackMessages)
+			} else {
+				primes = memories.Peek(r.Context(), number, counting, feedbackMessages)
+			}
+			results[i].Primes = &primes
+		}
+		// Workers take the next number until there are none left, or the client is gone.
+		// Every result is marked ready once it is checked, so a stream can send them in order while the rest are checked.
+		ctx, cancel := context.WithCancel(r.Context())
+		var wait sync.WaitGroup
+		defer wait.Wait()
+		defer cancel()
+		ready := make([]chan struct{}, len(numbers))
+		for i := range ready {
+			ready[i] = make(chan struct{}, 1)
+		}
+		var taken int64 = -1
+		for worker := 0; worker < runtime.NumCPU(); worker++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				for i := int(atomic.AddInt64(&taken, 1)); i < len(numbers) && ctx.Err() == nil; i = int(atomic.AddInt64(&taken, 1)) {
+					check(i)
+					ready[i] <- struct{}{}
+				}
+			}()
+		}
+		if !ndjson {
+			wait.Wait()
+			if ctx.Err() == nil {
+				sendAsJSONResponse(w, responses.Batch{Results: results})
+			}
+			return
+		}
+		stream := startNDJSON(w, r)
+		for i := range results {
+			select {
+			case <-ready[i]:
+			default:
+				// What was sent so far goes out while waiting for the next one
+				if !stream.flush() {
+					return
+				}
+				select {
+				case <-ready[i]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !stream.send(results[i]) {
+				return
+			}
+		}
+		stream.flush()
+	}
+}
+
+var errBatchTooLarge = errors.New("batch too large")
+
+// readBatch stops reading once there are more numbers than the limit, rather than reading the whole body first.
+func readBatch(body io.Reader, ndjson bool, limit int) ([]json.Number, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	if !ndjson {
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, errors.New("expected a JSON array")
+		}
+	}
+	numbers := []json.Number{}
+	for {
+		if !ndjson && !decoder.More() {
+			_, err := decoder.Token()
+			return numbers, err
+		}
+		var number json.Number
+		err := decoder.Decode(&number)
+		if ndjson && err == io.EOF {
+			return numbers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(numbers) == limit {
+			return nil, errBatchTooLarge
+		}
+		numbers = append(numbers, number)
+	}
+}
+
+func factorsHandler(w http.ResponseWriter, r *http.Request) {
+	number, ok := numberFromPath(w, r)
+	if !ok {
+		return
+	}
+	factors, remainder := primes.Factorize(r.Context(), number)
+	if r.Context().Err() != nil {
+		return
+	}
+	response := responses.Factors{Number: json.Number(number.String()), Factors: []responses.Factor{}, Complete: remainder == nil}
+	for _, factor := range factors {
+		response.Factors = append(response.Factors, responses.Factor{Prime: json.Number(factor.Prime.String()), Exponent: factor.Exponent})
+	}
+	if remainder != nil {
+		response.Remainder = json.Number(remainder.String())
+	}
+	sendAsJSONResponse(w, response)
+}
+
+func numberFromPath(w http.ResponseWriter, r *http.Request) (*big.Int, bool) {
+	potentialNumber := mux.Vars(r)["number"]
+	if len(potentialNumber) > maxDigits {
+		http.Error(w, fmt.Sprintf("Can't handle more than %d digits", maxDigits), http.StatusBadRequest)
+		return nil, false
+	}
+	number, ok := new(big.Int).SetString(potentialNumber, 10)
+	if !ok {
+		logging.FromContext(r.Context()).Warn("Not an integer", "number", potentialNumber)
+		http.Error(w, fmt.Sprintf("Not an integer: %s", potentialNumber), http.StatusBadRequest)
+	}
+	return number, ok
+}
+
+func primeRangeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	from, err := strconv.Atoi(vars["from"])
+	if err != nil || from < 0 {
+		http.Error(w, fmt.Sprintf("Not a positive integer: %s", vars["from"]), http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.Atoi(vars["to"])
+	if err != nil || to < from || to > primes.MaxRangeLimit {
+		http.Error(w, fmt.Sprintf("Not an integer between %d and %d: %s", from, primes.MaxRangeLimit, vars["to"]), http.StatusBadRequest)
+		return
+	}
+	if wantsNDJSON(r) {
+		streamPrimeRange(w, r, from, to)
+		return
+	}
+	limit, err := intQueryParameter(r, "limit", defaultPageSize)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		http.Error(w, fmt.Sprintf("Limit must be an integer between 1 and %d", maxPageSize), http.StatusBadRequest)
+		return
+	}
+	found := primes.Range(from, to, limit)
+	response := responses.PrimeRange{From: from, To: to, Primes: found}
+	if len(found) == limit && found[len(found)-1] < to {
+		next := found[len(found)-1] + 1
+		response.Next = &next
+	}
+	sendAsJSONResponse(w, response)
+}
+
+func intQueryParameter(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func historyHandler(memories *history.Service) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := historyFilter(r)
 		if err != nil {
-			log.Println(potentialNumber, "is not an integer.")
-			http.Error(w, fmt.Sprintf("Not an integer: %s", potentialNumber), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		memories.Update(number)
-		sendAsJSONResponse(w, memories.ToPrimeResponse(number, feedbackMessages))
+		if !wantsNDJSON(r) {
+			sendAsJSONResponse(w, memories.ToHistoryResponse(filter))
+			return
+		}
+		// The totals are left out, they are only known after the last request
+		stream := startNDJSON(w, r)
+		memories.EachRequest(filter, func(request responses.Request) bool {
+			return stream.send(request)
+		})
+		stream.flush()
 	}
 }
 
-func historyHandler(memories history.Service) func(http.ResponseWriter, *http.Request) {
+func historyDELETEHandler(memories *history.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sendAsJSONResponse(w, memories.ToHistoryResponse())
+		if err := memories.Reset(); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to reset history", "error", err)
+			http.Error(w, "Can't reset history", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+func historyFilter(r *http.Request) (history.Filter, error) {
+	query := r.URL.Query()
+	filter := history.Filter{SortBy: history.SortByNumber, Counting: history.Counting{Client: query.Get("client")}}
+	for name, bound := range map[string]**big.Int{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			number, ok := new(big.Int).SetString(value, 10)
+			if !ok {
+				return filter, fmt.Errorf("not an integer for %s: %s", name, value)
+			}
+			*bound = number
+		}
+	}
+	for name, moment := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a time like 2021-03-01T12:00:00Z, not %s", name, value)
+			}
+			*moment = parsed
+		}
+	}
+	if sortBy := query.Get("sort"); sortBy != "" {
+		filter.SortBy = sortBy
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, fmt.Errorf("order must be \"asc\" or \"desc\", not %q", order)
+	}
+	return filter, filter.Validate()
+}
+
 func feedbackMessagesGETHandler(feedbackMessages *messages.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sendAsJSONResponse(w, feedbackMessages.Get())
 	}
 }
 
-/*
-  Endpoint should have some security... We wouldn't want just anyone updating this.
-*/
+// streamPrimeRange writes every prime in the range on a line of its own, without pages.
+// It stops sieving once the client is gone.
+func streamPrimeRange(w http.ResponseWriter, r *http.Request, from int, to int) {
+	limit, err := intQueryParameter(r, "limit", math.MaxInt32)
+	if err != nil || limit < 1 {
+		http.Error(w, "Limit must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	stream := startNDJSON(w, r)
+	sent := 0
+	primes.Each(from, to, func(prime int) bool {
+		sent++
+		return stream.send(prime) && sent < limit
+	})
+	stream.flush()
+}
+
 func feedbackMessagesPOSTHandler(feedbackMessages *messages.Service) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var messages responses.Messages
@@ -128,7 +709,7 @@ func feedbackMessagesPOSTHandler(feedbackMessages *messages.Service) func(http.R
 			}
 			return
 		}
-		err = feedbackMessages.Update(messages)
+		err = feedbackMessages.Update(r.Context(), messages)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -137,6 +718,54 @@ func feedbackMessagesPOSTHandler(feedbackMessages *messages.Service) func(http.R
 	}
 }
 
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// ndjsonStream writes a JSON value per line. It is buffered, but flushed to the client every flushEvery values,
+// so the client gets them while they are produced without a write for every line.
+type ndjsonStream struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	pending int
+}
+
+const flushEvery = 1000
+
+func startNDJSON(w http.ResponseWriter, r *http.Request) *ndjsonStream {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	buffer := bufio.NewWriter(w)
+	return &ndjsonStream{ctx: r.Context(), w: w, buffer: buffer, encoder: json.NewEncoder(buffer)}
+}
+
+// send returns false once the client is gone, after which nothing more should be sent.
+func (s *ndjsonStream) send(value interface{}) bool {
+	if s.ctx.Err() != nil {
+		return false
+	}
+	if err := s.encoder.Encode(value); err != nil {
+		return false
+	}
+	if s.pending++; s.pending >= flushEvery {
+		return s.flush()
+	}
+	return true
+}
+
+func (s *ndjsonStream) flush() bool {
+	s.pending = 0
+	if err := s.buffer.Flush(); err != nil {
+		return false
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return s.ctx.Err() == nil
+}
+
 func sendAsJSONResponse(w http.ResponseWriter, response interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
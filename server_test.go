@@ -1,19 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
+	"tbp.com/user/hello/auth"
+	"tbp.com/user/hello/config"
 	"tbp.com/user/hello/history"
+	"tbp.com/user/hello/logging"
 	"tbp.com/user/hello/messages"
+	"tbp.com/user/hello/primes"
+	"tbp.com/user/hello/repository"
 	"tbp.com/user/hello/responses"
 	"testing"
+	"time"
 )
 
 func TestServerUnknownPaths(t *testing.T) {
@@ -50,8 +61,8 @@ func TestIsPrime(t *testing.T) {
 		number   int
 		expected responses.Primes
 	}{
-		{number: 2, expected: responses.Primes{IsPrime: true, Message: "It is prime. Hurray!"}},
-		{number: 22, expected: responses.Primes{IsPrime: false, Message: "No"}},
+		{number: 2, expected: responses.Primes{IsPrime: true, Message: "It is prime. Hurray!", Verdict: "prime", Certainty: 1}},
+		{number: 22, expected: responses.Primes{IsPrime: false, Message: "No", Verdict: "not prime", Certainty: 1, SmallestFactor: "2"}},
 	}
 
 	for _, primeCase := range primeCases {
@@ -64,9 +75,541 @@ func TestIsPrime(t *testing.T) {
 	}
 }
 
+func TestPrimesInRange(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	next := 8
+	testCases := []struct {
+		query    string
+		expected responses.PrimeRange
+	}{
+		{"from=0&to=30", responses.PrimeRange{From: 0, To: 30, Primes: []int{2, 3, 5, 7, 11, 13, 17, 19, 23, 29}}},
+		{"from=0&to=30&limit=4", responses.PrimeRange{From: 0, To: 30, Primes: []int{2, 3, 5, 7}, Next: &next}},
+		{"from=24&to=28", responses.PrimeRange{From: 24, To: 28, Primes: []int{}}},
+	}
+	for _, testCase := range testCases {
+		t.Run("GETs primes for "+testCase.query, func(t *testing.T) {
+			response := doGETRequest(t, server.URL+"/primes?"+testCase.query)
+			defer response.Body.Close()
+
+			assertStatus200(t, response)
+			assertJsonHeader(t, response)
+
+			var actual responses.PrimeRange
+			unmarshal(t, response, &actual)
+			if fmt.Sprint(actual.Primes) != fmt.Sprint(testCase.expected.Primes) ||
+				actual.From != testCase.expected.From || actual.To != testCase.expected.To ||
+				(actual.Next == nil) != (testCase.expected.Next == nil) ||
+				(actual.Next != nil && *actual.Next != *testCase.expected.Next) {
+				t.Errorf("Expected body %+v, but got %+v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPrimesInRangeAsNDJSON(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	// Without pages, so more than the page size
+	response := doRequest(t, server.URL+"/primes?from=0&to=100000", http.MethodGet, nil, "Accept", "application/x-ndjson")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	if header := response.Header.Get("Content-Type"); header != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON, but got %q", header)
+	}
+	lines := 0
+	last := ""
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		lines++
+		last = scanner.Text()
+	}
+	if lines != 9592 || last != "99991" {
+		t.Errorf("Expected 9592 primes up to 99991, but got %d up to %s", lines, last)
+	}
+}
+
+func TestStreamingStopsWhenTheClientIsGone(t *testing.T) {
+	server := setupServer(t)
+
+	response := doRequest(t, server.URL+fmt.Sprintf("/primes?from=0&to=%d", primes.MaxRangeLimit), http.MethodGet, nil, "Accept", "application/x-ndjson")
+	if line, err := bufio.NewReader(response.Body).ReadString('\n'); err != nil || line != "2\n" {
+		t.Fatalf("Expected the first prime, but got %q: %v", line, err)
+	}
+	response.Body.Close()
+	// Closing waits for the request to be handled, which would take hours when the sieve didn't stop
+	closed := make(chan struct{}, 1)
+	go func() {
+		server.Close()
+		closed <- struct{}{}
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected streaming to stop when the client is gone")
+	}
+}
+
+func TestPrimesInRangeRejectsInvalidBounds(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	for _, query := range []string{
+		"from=ten&to=20",
+		"from=30&to=20",
+		"from=0&to=20&limit=0",
+		"from=0&to=20&limit=1001",
+	} {
+		t.Run("Rejects "+query, func(t *testing.T) {
+			response := doGETRequest(t, server.URL+"/primes?"+query)
+			defer response.Body.Close()
+			if response.StatusCode != 400 {
+				t.Errorf("Expected status code 400, but got \"%d\"", response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestIsPrimeBeyondInt64(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	primeCases := []struct {
+		number   string
+		expected responses.Primes
+	}{
+		{
+			number:   "170141183460469231731687303715884105727",
+			expected: responses.Primes{IsPrime: true, Message: "It is prime. Hurray!", Verdict: "probably prime", Rounds: primes.MillerRabinRounds, Certainty: primes.Describe(big.NewInt(0).Lsh(big.NewInt(1), 127), true).Certainty},
+		},
+		{
+			number:   "170141183460469231731687303715884105729",
+			expected: responses.Primes{IsPrime: false, Message: "No", Verdict: "not prime", Certainty: 1, SmallestFactor: "3"},
+		},
+	}
+
+	for _, primeCase := range primeCases {
+		t.Run("GETs 200 on big integer prime test", func(t *testing.T) {
+			response := doGETRequest(t, fmt.Sprintf("%s/primes/%s", server.URL, primeCase.number))
+			defer response.Body.Close()
+
+			assertIsPrimeResponse(t, response, primeCase.expected)
+		})
+	}
+}
+
+func TestIsPrimeRejectsTooManyDigits(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	response := doGETRequest(t, fmt.Sprintf("%s/primes/1%s", server.URL, strings.Repeat("0", maxDigits)))
+	defer response.Body.Close()
+	if response.StatusCode != 400 {
+		t.Errorf("Expected status code 400, but got \"%d\"", response.StatusCode)
+	}
+}
+
+func TestFactors(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	testCases := []struct {
+		number   string
+		expected []responses.Factor
+	}{
+		{"1", []responses.Factor{}},
+		{"97", []responses.Factor{{Prime: "97", Exponent: 1}}},
+		{"360", []responses.Factor{{Prime: "2", Exponent: 3}, {Prime: "3", Exponent: 2}, {Prime: "5", Exponent: 1}}},
+		{"1000000016000000063", []responses.Factor{{Prime: "1000000007", Exponent: 1}, {Prime: "1000000009", Exponent: 1}}},
+	}
+	for _, testCase := range testCases {
+		t.Run("GETs factors of "+testCase.number, func(t *testing.T) {
+			response := doGETRequest(t, fmt.Sprintf("%s/primes/%s/factors", server.URL, testCase.number))
+			defer response.Body.Close()
+
+			assertStatus200(t, response)
+			assertJsonHeader(t, response)
+
+			var actual responses.Factors
+			unmarshal(t, response, &actual)
+			if string(actual.Number) != testCase.number || !actual.Complete || actual.Remainder != "" ||
+				fmt.Sprint(actual.Factors) != fmt.Sprint(testCase.expected) {
+				t.Errorf("Expected factors %+v, but got %+v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	doGETRequest(t, server.URL+"/primes/7").Body.Close()
+	doGETRequest(t, server.URL+"/primes/7").Body.Close()
+
+	response := doGETRequest(t, server.URL+"/metrics")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`hello_http_requests_total{route="/primes/{number:[0-9]+}",method="GET",code="200"}`,
+		`hello_http_request_duration_seconds_count{route="/primes/{number:[0-9]+}",method="GET"}`,
+		"hello_primality_check_duration_seconds_count",
+		"hello_history_numbers",
+		"hello_repeated_number_requests_ratio",
+		`hello_feedback_messages_total{kind="prime",lower_limit="0"}`,
+		"# TYPE hello_persist_failures_total counter",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to contain %s, but got\n%s", expected, body)
+		}
+	}
+}
+
+func TestHealthAndVersion(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	response := doGETRequest(t, server.URL+"/healthz")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+
+	response = doGETRequest(t, server.URL+"/version")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	assertJsonHeader(t, response)
+	var actual responses.Version
+	unmarshal(t, response, &actual)
+	if actual.Version == "" || actual.GoVersion != runtime.Version() || actual.SchemaVersion != repository.SchemaVersion {
+		t.Errorf("Expected a version, %s and schema version %d, but got %+v", runtime.Version(), repository.SchemaVersion, actual)
+	}
+}
+
+func TestUI(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	// The default client follows the redirect from /ui to /ui/
+	for path, contentType := range map[string]string{"/ui": "text/html", "/ui/app.js": "javascript", "/ui/style.css": "text/css"} {
+		response := doGETRequest(t, server.URL+path)
+		defer response.Body.Close()
+		assertStatus200(t, response)
+		if header := response.Header.Get("Content-Type"); !strings.Contains(header, contentType) {
+			t.Errorf("Expected %s to be %s, but got %q", path, contentType, header)
+		}
+	}
+	response := doGETRequest(t, server.URL+"/ui/missing.js")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404, but got %d", response.StatusCode)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	response := doGETRequest(t, server.URL+"/readyz")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	assertJsonHeader(t, response)
+	var actual responses.Readiness
+	unmarshal(t, response, &actual)
+	if !actual.Ready || len(actual.Checks) == 0 {
+		t.Errorf("Expected to be ready, but got %+v", actual)
+	}
+}
+
+func TestNotReadyWhenDataCanNotBeWritten(t *testing.T) {
+	memories := history.SetupWith(history.Memories{}, failingRepository{}, repository.InitializeMemoryJournal())
+	defer memories.Close()
+	if err := memories.Reset(); err == nil {
+		t.Fatal("Expected persisting to fail")
+	}
+	feedbackMessages, err := messages.Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorization, err := auth.SetupWith()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration := config.Default()
+	configuration.DataFolder = filepath.Join(t.TempDir(), "missing")
+	server := httptest.NewServer(setupRouter(configuration, memories, feedbackMessages, authorization))
+	defer server.Close()
+
+	response := doGETRequest(t, server.URL+"/readyz")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, but got %d", response.StatusCode)
+	}
+	var actual responses.Readiness
+	unmarshal(t, response, &actual)
+	failed := map[string]bool{}
+	for _, check := range actual.Checks {
+		failed[check.Name] = !check.OK
+	}
+	if actual.Ready || !failed["dataFolder"] || !failed["historyPersisted"] || failed["messagesPersisted"] {
+		t.Errorf("Expected the data folder and history to fail, but got %+v", actual)
+	}
+}
+
+type failingRepository struct{}
+
+func (failingRepository) Persist(data interface{}) error { return errors.New("disk full") }
+func (failingRepository) ReadAll(data interface{}) error { return nil }
+func (failingRepository) Close() error                   { return nil }
+
+func TestRequestIDs(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	response := doRequest(t, server.URL+"/primes/7", http.MethodGet, nil, logging.RequestIDHeader, "trace-me")
+	defer response.Body.Close()
+	if actual := response.Header.Get(logging.RequestIDHeader); actual != "trace-me" {
+		t.Errorf("Expected the request ID that was sent, but got %q", actual)
+	}
+
+	response = doGETRequest(t, server.URL+"/unknown")
+	defer response.Body.Close()
+	if actual := response.Header.Get(logging.RequestIDHeader); actual == "" {
+		t.Error("Expected a generated request ID")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	response := doRequestAs(t, reader, server.URL+"/primes/batch", http.MethodPost, strings.NewReader(`[7, 9, "11", -3, 1.5]`))
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	assertJsonHeader(t, response)
+	var actual responses.Batch
+	unmarshal(t, response, &actual)
+	expected := []struct {
+		number  json.Number
+		isPrime bool
+		err     string
+	}{{"7", true, ""}, {"9", false, ""}, {"11", true, ""}, {"-3", false, "Not a positive integer: -3"}, {"1.5", false, "Not a positive integer: 1.5"}}
+	if len(actual.Results) != len(expected) {
+		t.Fatalf("Expected %d results, but got %+v", len(expected), actual)
+	}
+	for i, result := range actual.Results {
+		if result.Number != expected[i].number || result.Error != expected[i].err || (result.Primes == nil) != (expected[i].err != "") ||
+			(result.Primes != nil && result.IsPrime != expected[i].isPrime) {
+			t.Errorf("Expected %+v, but got %+v", expected[i], result)
+		}
+	}
+	if actual.Results[1].SmallestFactor != "3" || actual.Results[1].Message != "No" {
+		t.Errorf("Expected 9 to be answered like /primes/9, but got %+v", actual.Results[1].Primes)
+	}
+
+	history := doGETRequestAs(t, reader, server.URL+"/history")
+	defer history.Body.Close()
+	var remembered responses.History
+	unmarshal(t, history, &remembered)
+	if remembered.Totals.TotalRequests != 0 {
+		t.Errorf("Expected a batch not to count in the history by default, but got %+v", remembered)
+	}
+}
+
+func TestBatchCountsHistoryAndStreams(t *testing.T) {
+	configuration := config.Default()
+	configuration.DataFolder = t.TempDir()
+	configuration.BatchCountsHistory = true
+	server := setupServerWith(t, configuration)
+	defer server.Close()
+
+	response := doRequest(t, server.URL+"/primes/batch", http.MethodPost, strings.NewReader("9\n9\n9\n4\n"), "Authorization", "Bearer "+reader.Secret, "Content-Type", "application/x-ndjson")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	if header := response.Header.Get("Content-Type"); header != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON, but got %q", header)
+	}
+	decoder := json.NewDecoder(response.Body)
+	var numbers []json.Number
+	for decoder.More() {
+		var result responses.BatchPrimes
+		if err := decoder.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		numbers = append(numbers, result.Number)
+	}
+	if !reflect.DeepEqual(numbers, []json.Number{"9", "9", "9", "4"}) {
+		t.Errorf("Expected a line for every number in order, but got %v", numbers)
+	}
+
+	history := doGETRequestAs(t, reader, server.URL+"/history")
+	defer history.Body.Close()
+	var remembered responses.History
+	unmarshal(t, history, &remembered)
+	if remembered.Totals.TotalRequests != 4 || remembered.Totals.DistinctNumbers != 2 {
+		t.Errorf("Expected the batch to count 4 requests for 2 numbers, but got %+v", remembered.Totals)
+	}
+}
+
+func TestSignedBatchCountsForTheClient(t *testing.T) {
+	configuration := config.Default()
+	configuration.DataFolder = t.TempDir()
+	configuration.BatchCountsHistory = true
+	server := setupServerWith(t, configuration)
+	defer server.Close()
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/primes/batch", strings.NewReader(`[9, 4]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unix := fmt.Sprint(time.Now().Unix())
+	signature, err := auth.Sign(request, editor.Secret, unix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("HMAC %s:%x", editor.ID, signature))
+	request.Header.Set("X-Timestamp", unix)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	assertStatus200(t, response)
+
+	history := doGETRequestAs(t, reader, server.URL+"/history?client=client:"+editor.ID)
+	defer history.Body.Close()
+	var remembered responses.History
+	unmarshal(t, history, &remembered)
+	if remembered.Totals.TotalRequests != 2 {
+		t.Errorf("Expected the batch to count for the client that signed it, but got %+v", remembered.Totals)
+	}
+}
+
+func TestBatchRejectsInvalidAndLargeBatches(t *testing.T) {
+	configuration := config.Default()
+	configuration.DataFolder = t.TempDir()
+	configuration.BatchLimit = 3
+	server := setupServerWith(t, configuration)
+	defer server.Close()
+
+	for body, expected := range map[string]int{
+		`[1, 2, 3, 4]`:   http.StatusRequestEntityTooLarge,
+		`{"numbers":[]}`: http.StatusBadRequest,
+		`[1, "two"]`:     http.StatusBadRequest,
+		`[1, 2`:          http.StatusBadRequest,
+		`[1, 2, 3]`:      http.StatusOK,
+		`["` + strings.Repeat("1", 3*(maxDigits+batchOverhead)) + `"]`: http.StatusRequestEntityTooLarge,
+	} {
+		response := doRequestAs(t, reader, server.URL+"/primes/batch", http.MethodPost, strings.NewReader(body))
+		response.Body.Close()
+		if response.StatusCode != expected {
+			t.Errorf("Expected status code %d for %s, but got %d", expected, body, response.StatusCode)
+		}
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	configuration := config.Default()
+	configuration.DataFolder = t.TempDir()
+	configuration.RateLimit = 60
+	configuration.RateLimitBurst = 3
+	configuration.RepeatRateLimit = 1
+	configuration.RepeatRateLimitBurst = 2
+	configuration.RateLimitMessage = "Enough already"
+	configuration.ClientHeader = "X-Client-ID"
+	server := setupServerWith(t, configuration)
+	defer server.Close()
+
+	for _, number := range []string{"9", "09"} {
+		response := doGETRequest(t, server.URL+"/primes/"+number)
+		response.Body.Close()
+		assertStatus200(t, response)
+	}
+	response := doGETRequest(t, server.URL+"/primes/9")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code 429 when repeating 9, but got %d", response.StatusCode)
+	}
+	if retryAfter := response.Header.Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("Expected to retry after 60 seconds, but got %q", retryAfter)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "Enough already\n" {
+		t.Errorf("Expected the configured message, but got %q", body)
+	}
+
+	response = doGETRequestAs(t, reader, server.URL+"/primes/9")
+	response.Body.Close()
+	assertStatus200(t, response)
+	for _, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		response = doGETRequest(t, server.URL+"/primes/10")
+		response.Body.Close()
+		if response.StatusCode != expected {
+			t.Errorf("Expected status code %d after running out of requests, but got %d", expected, response.StatusCode)
+		}
+	}
+	response = doRequest(t, server.URL+"/primes/11", http.MethodGet, nil, "X-Client-ID", "someone-else")
+	response.Body.Close()
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status code 429 when only changing the client header, but got %d", response.StatusCode)
+	}
+
+	response = doGETRequestAs(t, admin, server.URL+"/ratelimits")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	var actual responses.RateLimits
+	unmarshal(t, response, &actual)
+	if actual.PerMinute != 60 || len(actual.Clients) != 2 || actual.Clients[0].Client != "client:dashboard" ||
+		actual.Clients[1].Client != "ip:127.0.0.1" || len(actual.Clients[1].Numbers) != 2 || actual.Clients[1].Numbers[1].Number != "9" {
+		t.Errorf("Expected the limits of the reader and of localhost, but got %+v", actual)
+	}
+}
+
+func TestMessagesByClientCount(t *testing.T) {
+	for _, testCase := range []struct {
+		messageCount string
+		expected     string
+	}{
+		{config.CountGlobal, "No, and we already told you so!"},
+		{config.CountClient, "No"},
+	} {
+		t.Run("Counts "+testCase.messageCount, func(t *testing.T) {
+			configuration := config.Default()
+			configuration.DataFolder = t.TempDir()
+			configuration.MessageCount = testCase.messageCount
+			configuration.ClientHeader = "X-Client-ID"
+			server := setupServerWith(t, configuration)
+			defer server.Close()
+
+			for i := 0; i < 3; i++ {
+				doRequest(t, server.URL+"/primes/9", http.MethodGet, nil, "X-Client-ID", "alice").Body.Close()
+			}
+			response := doRequest(t, server.URL+"/primes/9", http.MethodGet, nil, "X-Client-ID", "bob")
+			defer response.Body.Close()
+			assertIsPrimeResponse(t, response, responses.Primes{IsPrime: false, Message: testCase.expected, Verdict: "not prime", Certainty: 1, SmallestFactor: "3"})
+
+			response = doGETRequestAs(t, reader, server.URL+"/history?client=header:alice")
+			defer response.Body.Close()
+			assertStatus200(t, response)
+			var actual responses.History
+			unmarshal(t, response, &actual)
+			if len(actual.Requests) != 1 || actual.Requests[0].Count != 3 || actual.Totals.TotalRequests != 3 {
+				t.Errorf("Expected 3 requests for 9 by alice, but got %+v", actual)
+			}
+		})
+	}
+}
+
 func TestMessageNotChangeOnRepetitionWithPrime(t *testing.T) {
 	server := setupServer(t, history.Memories{
-		23: {10, true},
+		"23": {Count: 10, IsPrime: true},
 	})
 	defer server.Close()
 
@@ -74,12 +617,12 @@ func TestMessageNotChangeOnRepetitionWithPrime(t *testing.T) {
 		response := doGETRequest(t, fmt.Sprintf("%s/primes/%d", server.URL, 23))
 		defer response.Body.Close()
 
-		assertIsPrimeResponse(t, response, responses.Primes{IsPrime: true, Message: "It is prime. Hurray!"})
+		assertIsPrimeResponse(t, response, responses.Primes{IsPrime: true, Message: "It is prime. Hurray!", Verdict: "prime", Certainty: 1})
 	})
 }
 
 func TestMessageChangeOnRepetitionWithNonPrime(t *testing.T) {
-	memories := history.Memories{4: {1, false}, 6: {2, false}}
+	memories := history.Memories{"4": {Count: 1, IsPrime: false}, "6": {Count: 2, IsPrime: false}}
 	server := setupServer(t, memories)
 	defer server.Close()
 
@@ -89,21 +632,21 @@ func TestMessageChangeOnRepetitionWithNonPrime(t *testing.T) {
 	}{{4, "No"}, {6, "No, and we already told you so!"}}
 
 	for _, repeatMessage := range repeatMessages {
-		t.Run(fmt.Sprintf("Message changes ask for non-prime after %d times", memories[repeatMessage.number].Count+1), func(t *testing.T) {
+		t.Run(fmt.Sprintf("Message changes ask for non-prime after %d times", memories[fmt.Sprint(repeatMessage.number)].Count+1), func(t *testing.T) {
 			response := doGETRequest(t, fmt.Sprintf("%s/primes/%d", server.URL, repeatMessage.number))
 			defer response.Body.Close()
 
-			assertIsPrimeResponse(t, response, responses.Primes{IsPrime: false, Message: repeatMessage.message})
+			assertIsPrimeResponse(t, response, responses.Primes{IsPrime: false, Message: repeatMessage.message, Verdict: "not prime", Certainty: 1, SmallestFactor: "2"})
 		})
 	}
 }
 
 func TestHistoryEndpoint(t *testing.T) {
-	memories := history.Memories{4: {1, false}, 6: {2, false}, 97: {100, true}}
+	memories := history.Memories{"4": {Count: 1, IsPrime: false}, "6": {Count: 2, IsPrime: false}, "97": {Count: 100, IsPrime: true}}
 	server := setupServer(t, memories)
 	defer server.Close()
 
-	response := doGETRequest(t, fmt.Sprintf("%s/history/", server.URL))
+	response := doGETRequestAs(t, reader, fmt.Sprintf("%s/history/", server.URL))
 	defer response.Body.Close()
 
 	assertStatus200(t, response)
@@ -114,9 +657,17 @@ func TestHistoryEndpoint(t *testing.T) {
 
 	expected := responses.History{
 		Requests: []responses.Request{
-			{Number: 4, Count: 1},
-			{Number: 6, Count: 2},
-			{Number: 97, Count: 100},
+			{Number: "4", Count: 1},
+			{Number: "6", Count: 2},
+			{Number: "97", Count: 100, IsPrime: true},
+		},
+		Totals: responses.Totals{
+			DistinctNumbers:  3,
+			TotalRequests:    103,
+			PrimeNumbers:     1,
+			NonPrimeNumbers:  2,
+			PrimeRequests:    100,
+			NonPrimeRequests: 3,
 		},
 	}
 
@@ -142,6 +693,121 @@ func TestHistoryEndpoint(t *testing.T) {
 			assertFailure()
 		}
 	}
+	if actual.Totals != expected.Totals {
+		assertFailure()
+	}
+}
+
+func TestHistoryInRangeSorted(t *testing.T) {
+	memories := history.Memories{
+		"4":  {Count: 1, IsPrime: false},
+		"6":  {Count: 5, IsPrime: false},
+		"7":  {Count: 3, IsPrime: true},
+		"97": {Count: 100, IsPrime: true},
+	}
+	server := setupServer(t, memories)
+	defer server.Close()
+
+	testCases := []struct {
+		query    string
+		expected []responses.Request
+	}{
+		{"from=5&to=50", []responses.Request{
+			{Number: "6", Count: 5},
+			{Number: "7", Count: 3, IsPrime: true},
+		}},
+		{"sort=count&order=desc", []responses.Request{
+			{Number: "97", Count: 100, IsPrime: true},
+			{Number: "6", Count: 5},
+			{Number: "7", Count: 3, IsPrime: true},
+			{Number: "4", Count: 1},
+		}},
+		{"from=5&order=desc", []responses.Request{
+			{Number: "97", Count: 100, IsPrime: true},
+			{Number: "7", Count: 3, IsPrime: true},
+			{Number: "6", Count: 5},
+		}},
+	}
+	for _, testCase := range testCases {
+		t.Run("GETs history for "+testCase.query, func(t *testing.T) {
+			response := doGETRequestAs(t, reader, server.URL+"/history?"+testCase.query)
+			defer response.Body.Close()
+
+			assertStatus200(t, response)
+			var actual responses.History
+			unmarshal(t, response, &actual)
+
+			if fmt.Sprint(actual.Requests) != fmt.Sprint(testCase.expected) {
+				t.Errorf("Expected requests %+v, but got %+v", testCase.expected, actual.Requests)
+			}
+			if actual.Totals.DistinctNumbers != len(testCase.expected) {
+				t.Errorf("Expected %d distinct numbers, but got %d", len(testCase.expected), actual.Totals.DistinctNumbers)
+			}
+		})
+	}
+}
+
+func TestHistorySinceUntil(t *testing.T) {
+	firstSeen := time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)
+	lastSeen := time.Date(2021, 3, 2, 9, 15, 0, 0, time.UTC)
+	server := setupServer(t, history.Memories{
+		"4": {Count: 3, FirstSeen: firstSeen, LastSeen: lastSeen, Hours: map[int64]int{firstSeen.Unix() / 3600: 1, lastSeen.Unix() / 3600: 2}},
+		"5": {Count: 1, IsPrime: true, FirstSeen: firstSeen, LastSeen: firstSeen, Hours: map[int64]int{firstSeen.Unix() / 3600: 1}},
+	})
+	defer server.Close()
+
+	response := doGETRequestAs(t, reader, server.URL+"/history?since=2021-03-02T00:00:00Z&until=2021-03-02T23:59:59Z")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	var actual responses.History
+	unmarshal(t, response, &actual)
+	if len(actual.Requests) != 1 || actual.Requests[0].Number != "4" || actual.Requests[0].Count != 2 ||
+		!actual.Requests[0].FirstSeen.Equal(firstSeen) || !actual.Requests[0].LastSeen.Equal(lastSeen) {
+		t.Errorf("Expected 2 requests for 4 on the second of March, but got %+v", actual.Requests)
+	}
+}
+
+func TestHistoryAsNDJSON(t *testing.T) {
+	server := setupServer(t, history.Memories{"4": {Count: 2}, "7": {Count: 1, IsPrime: true}})
+	defer server.Close()
+
+	response := doRequest(t, server.URL+"/history?sort=count&order=desc", http.MethodGet, nil, "Authorization", "Bearer "+reader.Secret, "Accept", "application/x-ndjson")
+	defer response.Body.Close()
+	assertStatus200(t, response)
+	decoder := json.NewDecoder(response.Body)
+	var requests []responses.Request
+	for decoder.More() {
+		var request responses.Request
+		if err := decoder.Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, request)
+	}
+	if len(requests) != 2 || requests[0].Number != "4" || requests[0].Count != 2 || requests[1].Number != "7" || !requests[1].IsPrime {
+		t.Errorf("Expected a line for 4 and then for 7, but got %+v", requests)
+	}
+}
+
+func TestHistoryRejectsInvalidFilters(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	for _, query := range []string{
+		"from=ten",
+		"from=50&to=5",
+		"sort=time",
+		"order=up",
+		"since=yesterday",
+		"since=2021-03-02T00:00:00Z&until=2021-03-01T00:00:00Z",
+	} {
+		t.Run("Rejects "+query, func(t *testing.T) {
+			response := doGETRequestAs(t, reader, server.URL+"/history?"+query)
+			defer response.Body.Close()
+			if response.StatusCode != 400 {
+				t.Errorf("Expected status code 400, but got \"%d\"", response.StatusCode)
+			}
+		})
+	}
 }
 
 func TestAllowsOnlyDefinedMethods(t *testing.T) {
@@ -169,8 +835,9 @@ func TestCurrentResponseMessages(t *testing.T) {
 
 	expected := responses.Messages{
 		Messages: []responses.Message{
-			{0, "No"},
-			{3, "No, and we already told you so!"},
+			{LowerLimit: 0, Message: "No", Kind: messages.KindNotPrime},
+			{LowerLimit: 3, Message: "No, and we already told you so!", Kind: messages.KindNotPrime},
+			{LowerLimit: 0, Message: "It is prime. Hurray!", Kind: messages.KindPrime},
 		},
 	}
 
@@ -183,16 +850,16 @@ func TestCurrentResponseMessages(t *testing.T) {
 
 func TestCanChangeResponseMessages(t *testing.T) {
 	server := setupServer(t, history.Memories{
-		22: {8999, false},
-		24: {9000, false},
+		"22": {Count: 8999, IsPrime: false},
+		"24": {Count: 9000, IsPrime: false},
 	})
 	defer server.Close()
 
 	newMessages := responses.Messages{
 		Messages: []responses.Message{
-			{0, "No"},
-			{3, "No, and we already told you so!"},
-			{9001, "It's over 9000!"},
+			{LowerLimit: 0, Message: "No"},
+			{LowerLimit: 3, Message: "No, and we already told you so!"},
+			{LowerLimit: 9001, Message: "It's over 9000!"},
 		},
 	}
 
@@ -200,7 +867,7 @@ func TestCanChangeResponseMessages(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	response := doPOSTRequest(t, server.URL+"/messages", bytes.NewReader(messageBytes))
+	response := doRequestAs(t, editor, server.URL+"/messages", http.MethodPost, bytes.NewReader(messageBytes))
 	defer response.Body.Close()
 
 	if response.StatusCode != 202 {
@@ -209,7 +876,8 @@ func TestCanChangeResponseMessages(t *testing.T) {
 
 	response, actualMessages := GETMessagesFromServer(t, server)
 	defer response.Body.Close()
-	if len(actualMessages.Messages) != len(newMessages.Messages) {
+	// The messages for primes are left as they are
+	if len(actualMessages.Messages) != len(newMessages.Messages)+1 {
 		t.Errorf("Expected body %+v, but got %+v", newMessages, actualMessages)
 	}
 
@@ -234,8 +902,182 @@ func TestCanChangeResponseMessages(t *testing.T) {
 	}
 }
 
+func TestCanChangePrimeMessages(t *testing.T) {
+	server := setupServer(t, history.Memories{
+		"22": {Count: 1, IsPrime: false},
+		"23": {Count: 1, IsPrime: true},
+	})
+	defer server.Close()
+
+	newMessages := responses.Messages{
+		Messages: []responses.Message{
+			{LowerLimit: 0, Message: "Yes!", Kind: messages.KindPrime},
+			{LowerLimit: 2, Message: "Yes, {{.Number}} is still prime", Kind: messages.KindPrime},
+		},
+	}
+	messageBytes, err := json.Marshal(newMessages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := doRequestAs(t, editor, server.URL+"/messages", http.MethodPost, bytes.NewReader(messageBytes))
+	defer response.Body.Close()
+	if response.StatusCode != 202 {
+		t.Errorf("Expected status code 202, but got \"%d\"", response.StatusCode)
+	}
+
+	for _, expected := range []struct {
+		number  int
+		message string
+	}{{23, "Yes, 23 is still prime"}, {22, "No"}} {
+		response = doGETRequest(t, fmt.Sprintf("%s/primes/%d", server.URL, expected.number))
+		defer response.Body.Close()
+		var actual responses.Primes
+		unmarshal(t, response, &actual)
+		if actual.Message != expected.message {
+			t.Errorf("Expected message %q, but got %q", expected.message, actual.Message)
+		}
+	}
+}
+
+func TestPrimeMessagesNeedADefault(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	for _, newMessages := range []responses.MessageSlice{
+		{{LowerLimit: 2, Message: "Yes!", Kind: messages.KindPrime}},
+		{{LowerLimit: 0, Message: "Maybe", Kind: "perhaps"}},
+	} {
+		messageBytes, err := json.Marshal(responses.Messages{Messages: newMessages})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := doRequestAs(t, editor, server.URL+"/messages", http.MethodPost, bytes.NewReader(messageBytes))
+		defer response.Body.Close()
+		if response.StatusCode != 400 {
+			t.Errorf("Expected status code 400, but got \"%d\"", response.StatusCode)
+		}
+	}
+}
+
+func TestMessageTemplates(t *testing.T) {
+	server := setupServer(t, history.Memories{"21": {Count: 4, IsPrime: false}})
+	defer server.Close()
+
+	testCases := []struct {
+		message  string
+		expected int
+	}{
+		{"No, {{.Number}} is {{.Factor}}×{{.Cofactor}}, and you've asked {{.Count}} times", 202},
+		{"No, {{.Numbr}}", 400},
+		{"No, {{.Number", 400},
+	}
+	for _, testCase := range testCases {
+		t.Run("POSTs "+testCase.message, func(t *testing.T) {
+			messageBytes, err := json.Marshal(responses.Messages{Messages: []responses.Message{{LowerLimit: 0, Message: testCase.message}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := doRequestAs(t, editor, server.URL+"/messages", http.MethodPost, bytes.NewReader(messageBytes))
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expected {
+				t.Errorf("Expected status code %d, but got \"%d\"", testCase.expected, response.StatusCode)
+			}
+		})
+	}
+
+	response := doGETRequest(t, server.URL+"/primes/21")
+	defer response.Body.Close()
+	var actual responses.Primes
+	unmarshal(t, response, &actual)
+	if expected := "No, 21 is 3×7, and you've asked 5 times"; actual.Message != expected {
+		t.Errorf("Expected message %q, but got %q", expected, actual.Message)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	messageBytes, err := json.Marshal(responses.Messages{Messages: []responses.Message{{LowerLimit: 0, Message: "No"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		headers  []string
+		expected int
+	}{
+		{"Anyone can ask for primes", http.MethodGet, "/primes/23", nil, 200},
+		{"Anonymous can't check a batch", http.MethodPost, "/primes/batch", nil, 401},
+		{"Anyone can read history", http.MethodGet, "/history", nil, 200},
+		{"Anyone can read messages", http.MethodGet, "/messages", nil, 200},
+		{"Anonymous can't change messages", http.MethodPost, "/messages", nil, 401},
+		{"Wrong key can't change messages", http.MethodPost, "/messages", []string{"Authorization", "Bearer wrong"}, 401},
+		{"Reader can't change messages", http.MethodPost, "/messages", []string{"Authorization", "Bearer " + reader.Secret}, 403},
+		{"Editor can change messages", http.MethodPost, "/messages", []string{"Authorization", "Bearer " + editor.Secret}, 202},
+		{"Editor can't reset history", http.MethodDelete, "/history", []string{"Authorization", "Bearer " + editor.Secret}, 403},
+		{"Admin can reset history", http.MethodDelete, "/history", []string{"Authorization", "Bearer " + admin.Secret}, 204},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			response := doRequest(t, server.URL+testCase.path, testCase.method, bytes.NewReader(messageBytes), testCase.headers...)
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expected {
+				t.Errorf("Expected status code %d, but got \"%d\"", testCase.expected, response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+
+	messageBytes, err := json.Marshal(responses.Messages{Messages: []responses.Message{{LowerLimit: 0, Message: "Nope"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedRequest := func(secret string, timestamp time.Time) *http.Request {
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/messages", bytes.NewReader(messageBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		unix := fmt.Sprint(timestamp.Unix())
+		signature, err := auth.Sign(request, secret, unix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Authorization", fmt.Sprintf("HMAC %s:%x", editor.ID, signature))
+		request.Header.Set("X-Timestamp", unix)
+		return request
+	}
+	testCases := []struct {
+		name     string
+		request  *http.Request
+		expected int
+	}{
+		{"Accepts a valid signature", signedRequest(editor.Secret, time.Now()), 202},
+		{"Rejects a signature with the wrong secret", signedRequest("wrong", time.Now()), 401},
+		{"Rejects an old signature", signedRequest(editor.Secret, time.Now().Add(-time.Hour)), 401},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(testCase.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expected {
+				t.Errorf("Expected status code %d, but got \"%d\"", testCase.expected, response.StatusCode)
+			}
+		})
+	}
+}
+
 func GETMessagesFromServer(t *testing.T, server *httptest.Server) (*http.Response, responses.Messages) {
-	response := doGETRequest(t, fmt.Sprintf("%s/messages/", server.URL))
+	response := doGETRequestAs(t, reader, fmt.Sprintf("%s/messages/", server.URL))
 
 	assertStatus200(t, response)
 	assertJsonHeader(t, response)
@@ -252,21 +1094,40 @@ func unmarshal(t *testing.T, response *http.Response, actual interface{}) {
 	}
 }
 
+var (
+	reader = auth.Client{ID: "dashboard", Secret: "reader-secret", Role: auth.Reader}
+	editor = auth.Client{ID: "copywriter", Secret: "editor-secret", Role: auth.Editor}
+	admin  = auth.Client{ID: "operator", Secret: "admin-secret", Role: auth.Admin}
+)
+
 func setupServer(t *testing.T, memories ...history.Memories) *httptest.Server {
-	testFolder := "test_data"
-	err := os.RemoveAll(testFolder)
-	messagesService, err := messages.Setup(testFolder)
-	var historyService history.Service
+	configuration := config.Default()
+	configuration.DataFolder = t.TempDir()
+	return setupServerWith(t, configuration, memories...)
+}
+
+func setupServerWith(t *testing.T, configuration config.Config, memories ...history.Memories) *httptest.Server {
+	messagesService, err := messages.Setup(repository.InitializeMemory())
+	if err != nil {
+		t.Fatal(err)
+		return nil
+	}
+	var historyService *history.Service
 	if memories == nil {
-		historyService, err = history.Setup(testFolder)
+		historyService, err = history.Setup(repository.InitializeMemory(), repository.InitializeMemoryJournal())
 	} else {
-		historyService, err = history.SetupWith(memories[0], testFolder)
+		historyService = history.SetupWith(memories[0], repository.InitializeMemory(), repository.InitializeMemoryJournal())
+	}
+	if err != nil {
+		t.Fatal(err)
+		return nil
 	}
+	authorization, err := auth.SetupWith(reader, editor, admin)
 	if err != nil {
 		t.Fatal(err)
 		return nil
 	}
-	return httptest.NewServer(setupRouter(historyService, messagesService))
+	return httptest.NewServer(requestLogging(logging.New(ioutil.Discard), setupRouter(configuration, historyService, messagesService, authorization)))
 }
 
 func doGETRequest(t *testing.T, requestPath string) *http.Response {
@@ -277,11 +1138,22 @@ func doPOSTRequest(t *testing.T, requestPath string, body io.Reader) *http.Respo
 	return doRequest(t, requestPath, http.MethodPost, body)
 }
 
-func doRequest(t *testing.T, requestPath string, method string, body io.Reader) *http.Response {
+func doGETRequestAs(t *testing.T, client auth.Client, requestPath string) *http.Response {
+	return doRequestAs(t, client, requestPath, http.MethodGet, nil)
+}
+
+func doRequestAs(t *testing.T, client auth.Client, requestPath string, method string, body io.Reader) *http.Response {
+	return doRequest(t, requestPath, method, body, "Authorization", "Bearer "+client.Secret)
+}
+
+func doRequest(t *testing.T, requestPath string, method string, body io.Reader, headers ...string) *http.Response {
 	request, err := http.NewRequest(method, requestPath, body)
 	if err != nil {
 		t.Fatal(err)
 	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
 	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		t.Fatal(err)
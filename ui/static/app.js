@@ -0,0 +1,236 @@
+"use strict";
+
+// The secret is only kept for this browser tab, and sent along as API key.
+const secretKey = "hello.secret";
+
+const $ = (id) => document.getElementById(id);
+
+function status(text, isError) {
+    $("status").textContent = text || "";
+    $("status").className = isError ? "error" : "";
+}
+
+// Numbers can be larger than JavaScript can represent exactly, so those are kept as strings.
+function parse(text) {
+    return JSON.parse(text.replace(/("(?:number|smallestFactor|prime)":)(\d{16,})/g, '$1"$2"'));
+}
+
+async function request(method, path, body) {
+    const headers = {};
+    const secret = sessionStorage.getItem(secretKey);
+    if (secret) {
+        headers["Authorization"] = "Bearer " + secret;
+    }
+    if (body !== undefined) {
+        headers["Content-Type"] = "application/json";
+        body = JSON.stringify(body);
+    }
+    const response = await fetch(path, {method, headers, body});
+    const text = await response.text();
+    if (!response.ok) {
+        const reason = {401: "Enter a secret first", 403: "Your secret doesn't allow this"}[response.status];
+        throw new Error(reason || text.trim() || response.statusText);
+    }
+    return text ? parse(text) : undefined;
+}
+
+function run(action) {
+    return async (event) => {
+        if (event) {
+            event.preventDefault();
+        }
+        status("");
+        try {
+            await action();
+        } catch (error) {
+            status(error.message, true);
+        }
+    };
+}
+
+function query(parameters) {
+    const search = new URLSearchParams();
+    for (const [name, value] of Object.entries(parameters)) {
+        if (value !== "" && value !== undefined) {
+            search.set(name, value);
+        }
+    }
+    return search.toString();
+}
+
+function cell(row, text) {
+    row.insertCell().textContent = text;
+}
+
+function formatTime(time) {
+    return time ? new Date(time).toLocaleString() : "";
+}
+
+// Check
+
+$("check-form").addEventListener("submit", run(async () => {
+    const number = $("number").value.trim();
+    const result = await request("GET", "/primes/" + encodeURIComponent(number));
+    const element = $("check-result");
+    element.className = "result " + (result.isPrime ? "prime" : "not-prime");
+    let text = `${number}: ${result.message} (${result.verdict}`;
+    if (result.smallestFactor) {
+        text += `, divisible by ${result.smallestFactor}`;
+    }
+    element.textContent = text + ")";
+}));
+
+// History
+
+const historySort = {by: "number", order: "asc"};
+
+function showSortOrder() {
+    for (const button of document.querySelectorAll("th button[data-sort]")) {
+        if (button.dataset.sort === historySort.by) {
+            button.dataset.order = historySort.order;
+        } else {
+            delete button.dataset.order;
+        }
+    }
+}
+
+const loadHistory = run(async () => {
+    const history = await request("GET", "/history?" + query({
+        from: $("history-from").value.trim(),
+        to: $("history-to").value.trim(),
+        client: $("history-client").value.trim(),
+        sort: historySort.by,
+        order: historySort.order,
+    }));
+    showSortOrder();
+    const rows = $("history-rows");
+    rows.replaceChildren();
+    for (const request of history.requests) {
+        const row = rows.insertRow();
+        cell(row, request.number);
+        cell(row, request.count);
+        cell(row, request.isPrime ? "yes" : "no");
+        cell(row, formatTime(request.firstSeen));
+        cell(row, formatTime(request.lastSeen));
+    }
+    const totals = history.totals;
+    $("history-totals").textContent = `${totals.totalRequests} requests for ${totals.distinctNumbers} numbers, ` +
+        `${totals.primeRequests} for ${totals.primeNumbers} primes and ${totals.nonPrimeRequests} for ${totals.nonPrimeNumbers} others.`;
+    drawHistogram(history.requests);
+});
+
+// drawHistogram shows a bar per number in the order of the table, primes in another colour.
+function drawHistogram(requests) {
+    const svg = $("histogram");
+    const namespace = "http://www.w3.org/2000/svg";
+    svg.replaceChildren();
+    const shown = requests.slice(0, 100);
+    if (shown.length === 0) {
+        return;
+    }
+    const width = 1000, height = 200, labels = 15;
+    svg.setAttribute("viewBox", `0 0 ${width} ${height}`);
+    svg.setAttribute("preserveAspectRatio", "none");
+    const highest = Math.max(...shown.map((request) => request.count));
+    const barWidth = width / shown.length;
+    shown.forEach((request, i) => {
+        const bar = document.createElementNS(namespace, "rect");
+        const barHeight = (height - labels) * request.count / highest;
+        bar.setAttribute("x", i * barWidth + barWidth * 0.1);
+        bar.setAttribute("y", height - labels - barHeight);
+        bar.setAttribute("width", barWidth * 0.8);
+        bar.setAttribute("height", barHeight);
+        if (request.isPrime) {
+            bar.classList.add("prime");
+        }
+        const title = document.createElementNS(namespace, "title");
+        title.textContent = `${request.number}: ${request.count}`;
+        bar.appendChild(title);
+        svg.appendChild(bar);
+        if (shown.length <= 30) {
+            const label = document.createElementNS(namespace, "text");
+            label.setAttribute("x", i * barWidth + barWidth / 2);
+            label.setAttribute("y", height - 2);
+            label.setAttribute("text-anchor", "middle");
+            label.textContent = request.number;
+            svg.appendChild(label);
+        }
+    });
+}
+
+$("history-form").addEventListener("submit", loadHistory);
+for (const button of document.querySelectorAll("th button[data-sort]")) {
+    button.addEventListener("click", () => {
+        if (historySort.by === button.dataset.sort) {
+            historySort.order = historySort.order === "asc" ? "desc" : "asc";
+        } else {
+            historySort.by = button.dataset.sort;
+            historySort.order = button.dataset.sort === "count" ? "desc" : "asc";
+        }
+        loadHistory();
+    });
+}
+
+// Range
+
+let rangeNext;
+
+async function loadRange(from, append) {
+    const range = await request("GET", "/primes?" + query({from, to: $("range-to").value.trim(), limit: 1000}));
+    const element = $("range-primes");
+    const text = range.primes.join(" ");
+    element.textContent = append && text ? element.textContent + " " + text : text;
+    if (!element.textContent) {
+        element.textContent = "No primes in this range.";
+    }
+    rangeNext = range.next;
+    $("range-next").hidden = rangeNext === undefined;
+}
+
+$("range-form").addEventListener("submit", run(() => loadRange($("range-from").value.trim(), false)));
+$("range-next").addEventListener("click", run(() => loadRange(rangeNext, true)));
+
+// Messages
+
+function addMessageRow(message) {
+    const row = $("message-row").content.firstElementChild.cloneNode(true);
+    row.querySelector("[name=kind]").value = message.kind || "notPrime";
+    row.querySelector("[name=lowerLimit]").value = message.lowerLimit;
+    row.querySelector("[name=message]").value = message.message;
+    row.querySelector(".remove").addEventListener("click", () => row.remove());
+    $("messages-rows").appendChild(row);
+}
+
+const loadMessages = run(async () => {
+    const response = await request("GET", "/messages");
+    $("messages-rows").replaceChildren();
+    response.messages.forEach(addMessageRow);
+});
+
+$("add-message").addEventListener("click", () => addMessageRow({kind: "notPrime", lowerLimit: 0, message: ""}));
+$("reload-messages").addEventListener("click", loadMessages);
+$("messages-form").addEventListener("submit", run(async () => {
+    const messages = [...$("messages-rows").rows].map((row) => ({
+        kind: row.querySelector("[name=kind]").value,
+        lowerLimit: Number(row.querySelector("[name=lowerLimit]").value),
+        message: row.querySelector("[name=message]").value,
+    }));
+    await request("POST", "/messages", {messages});
+    status("Saved the feedback messages.");
+    await loadMessages();
+}));
+
+// Secret
+
+$("secret").value = sessionStorage.getItem(secretKey) || "";
+$("secret-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(secretKey, $("secret").value);
+});
+$("forget-secret").addEventListener("click", () => {
+    sessionStorage.removeItem(secretKey);
+    $("secret").value = "";
+});
+
+loadHistory();
+loadMessages();
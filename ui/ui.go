@@ -0,0 +1,20 @@
+// Package ui serves the single-page frontend, which talks to the same JSON endpoints as any other client.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the frontend below prefix, e.g. "/ui".
+func Handler(prefix string) http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix(prefix, http.FileServer(http.FS(files)))
+}